@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+)
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "Usage: goBotter [command] [flags]")
+	fmt.Fprintln(os.Stderr, "Without a command the bot is started.")
+	fmt.Fprintln(os.Stderr, "Commands:")
+	fmt.Fprintln(os.Stderr, "  export-quotes  export a quote base as json, csv or txt")
+	fmt.Fprintln(os.Stderr, "  import-quotes  import quotes from a json, csv or txt file")
+	fmt.Fprintln(os.Stderr, "  migrate-logs   merge title-named log directories into chat ID directories")
+	fmt.Fprintln(os.Stderr, "  rebuild-stats  recount stats from chat logs or update dumps into a fresh database")
+	fmt.Fprintln(os.Stderr, "  export-html    export the logs of a chat as a static html archive")
+}
+
+// runCommand runs a command line subcommand of the binary and returns the
+// process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "export-quotes":
+		return runExportQuotes(args[1:])
+	case "import-quotes":
+		return runImportQuotes(args[1:])
+	case "migrate-logs":
+		return runMigrateLogs(args[1:])
+	case "rebuild-stats":
+		return runRebuildStats(args[1:])
+	case "export-html":
+		return runExportHTML(args[1:])
+	default:
+		printUsage()
+		return 2
+	}
+}
+
+func resolveQuoteChannel(channel string, chatID int64) (string, error) {
+	if channel != "" && chatID != 0 {
+		return "", fmt.Errorf("use either -channel or -chat")
+	}
+	if chatID != 0 {
+		return getQuoteChannel(chatID), nil
+	}
+	if !isValidQuoteChannel(channel) {
+		return "", fmt.Errorf("quote base is required, pass -channel or -chat")
+	}
+	return channel, nil
+}
+
+func runExportQuotes(args []string) int {
+	flags := flag.NewFlagSet("export-quotes", flag.ExitOnError)
+	channel := flags.String("channel", "", "quote base name, e.g. #nnm")
+	chatID := flags.Int64("chat", 0, "chat ID, its bound quote base is exported")
+	format := flags.String("format", "json", "output format: json, csv or txt")
+	output := flags.String("o", "", "output file, stdout by default")
+	flags.Parse(args)
+
+	if !isQuotesFormat(*format) {
+		log.Println("Unknown format", *format)
+		return 2
+	}
+
+	if err := initStatsStorage(); err != nil {
+		log.Println("Can't initialize stats storage")
+		log.Println(err)
+		return 1
+	}
+
+	quoteChannel, err := resolveQuoteChannel(*channel, *chatID)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+
+	quotes, err := loadQuotes("chat = ? ORDER BY quote_id", quoteChannel)
+	if err != nil {
+		log.Println("Can't load quotes")
+		log.Println(err)
+		return 1
+	}
+
+	var w io.Writer = os.Stdout
+	if *output != "" {
+		f, err := os.Create(*output)
+		if err != nil {
+			log.Println("Can't create output file")
+			log.Println(err)
+			return 1
+		}
+		defer f.Close()
+		w = f
+	}
+
+	if err = writeQuotesExport(w, quoteChannel, *format, quotes); err != nil {
+		log.Println("Can't export quotes")
+		log.Println(err)
+		return 1
+	}
+	log.Printf("Exported %d quotes from %s", len(quotes), quoteChannel)
+	return 0
+}
+
+func runImportQuotes(args []string) int {
+	flags := flag.NewFlagSet("import-quotes", flag.ExitOnError)
+	channel := flags.String("channel", "", "quote base name, e.g. #nnm")
+	chatID := flags.Int64("chat", 0, "chat ID, quotes go to its bound quote base")
+	format := flags.String("format", "", "input format: json, csv or txt, guessed by extension by default")
+	flags.Parse(args)
+
+	if flags.NArg() != 1 {
+		log.Println("Pass exactly one file to import")
+		return 2
+	}
+	file := flags.Arg(0)
+
+	inputFormat := *format
+	if inputFormat == "" {
+		inputFormat = quotesFormatFromFileName(file)
+	}
+	if !isQuotesFormat(inputFormat) {
+		log.Println("Unknown format", inputFormat)
+		return 2
+	}
+
+	if err := initStatsStorage(); err != nil {
+		log.Println("Can't initialize stats storage")
+		log.Println(err)
+		return 1
+	}
+
+	quoteChannel, err := resolveQuoteChannel(*channel, *chatID)
+	if err != nil {
+		log.Println(err)
+		return 2
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		log.Println("Can't open input file")
+		log.Println(err)
+		return 1
+	}
+	defer f.Close()
+
+	quotes, err := readQuotesImport(f, inputFormat)
+	if err != nil {
+		log.Println("Can't parse input file")
+		log.Println(err)
+		return 1
+	}
+
+	result, err := importQuotes(context.Background(), quoteChannel, quotes)
+	if err != nil {
+		log.Println("Can't import quotes")
+		log.Println(err)
+		return 1
+	}
+	log.Printf("Imported into %s: added %d, duplicates %d, similar %d, deleted before %d, renumbered %d", quoteChannel, result.added, result.duplicates, result.similar, result.deleted, result.renumbered)
+	return 0
+}
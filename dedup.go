@@ -0,0 +1,330 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	quoteDraftPrefix        = "qa:"
+	quoteDraftTTL           = 24 * time.Hour
+	quoteDuplicateThreshold = 0.85
+	// Longer texts are cut before comparing to keep the edit distance cheap.
+	quoteDuplicateMaxRunes = 1000
+)
+
+// duplicateText keeps only letters and digits of the normalized text, so
+// punctuation, emoji and spacing don't hide a duplicate.
+func duplicateText(text string) []rune {
+	var result []rune
+	space := false
+	for _, r := range normalizeSearchText(text) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if space && len(result) > 0 {
+				result = append(result, ' ')
+			}
+			result = append(result, r)
+			space = false
+		} else {
+			space = true
+		}
+	}
+	if len(result) > quoteDuplicateMaxRunes {
+		result = result[:quoteDuplicateMaxRunes]
+	}
+	return result
+}
+
+// quoteSimilarity returns 1 for equal texts and goes down to 0 with the
+// Levenshtein distance between them.
+func quoteSimilarity(a []rune, b []rune) float64 {
+	longest := len(a)
+	if len(b) > longest {
+		longest = len(b)
+	}
+	if longest == 0 {
+		return 1
+	}
+
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return 1 - float64(prev[len(b)])/float64(longest)
+}
+
+func countRunes(text []rune) map[rune]int {
+	counts := make(map[rune]int, len(text))
+	for _, r := range text {
+		counts[r]++
+	}
+	return counts
+}
+
+// mayBeSimilar is a cheap check before the edit distance. Every edit changes
+// the counts of at most two letters by one, so half the difference of the
+// letter counts is a lower bound of the distance.
+func mayBeSimilar(target []rune, targetCounts map[rune]int, candidate []rune) bool {
+	longest := max(len(target), len(candidate))
+	if longest == 0 {
+		return true
+	}
+	if float64(min(len(target), len(candidate)))/float64(longest) < quoteDuplicateThreshold {
+		return false
+	}
+
+	diff := 0
+	candidateCounts := countRunes(candidate)
+	for r, count := range targetCounts {
+		diff += abs(count - candidateCounts[r])
+	}
+	for r, count := range candidateCounts {
+		if _, exists := targetCounts[r]; !exists {
+			diff += count
+		}
+	}
+	return 1-float64((diff+1)/2)/float64(longest) >= quoteDuplicateThreshold
+}
+
+func abs(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// hasSimilarQuote reports whether any of the texts, already passed through
+// duplicateText, is above quoteDuplicateThreshold for the target.
+func hasSimilarQuote(texts [][]rune, target []rune) bool {
+	targetCounts := countRunes(target)
+	for _, text := range texts {
+		if mayBeSimilar(target, targetCounts, text) && quoteSimilarity(target, text) >= quoteDuplicateThreshold {
+			return true
+		}
+	}
+	return false
+}
+
+// findDuplicateQuote looks for the existing quote most similar to the text,
+// only quotes above quoteDuplicateThreshold are reported.
+func findDuplicateQuote(channel string, text string) (int, float64, bool, error) {
+	rows, err := statsDB.Query("SELECT quote_id, text FROM quotes WHERE chat = ? AND deleted_at = 0", channel)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer rows.Close()
+
+	target := duplicateText(text)
+	targetCounts := countRunes(target)
+	bestId := 0
+	bestSimilarity := 0.0
+	for rows.Next() {
+		var id int
+		var quoteText string
+		if err = rows.Scan(&id, &quoteText); err != nil {
+			return 0, 0, false, err
+		}
+
+		candidate := duplicateText(quoteText)
+		if !mayBeSimilar(target, targetCounts, candidate) {
+			continue
+		}
+
+		similarity := quoteSimilarity(target, candidate)
+		if similarity > bestSimilarity {
+			bestId = id
+			bestSimilarity = similarity
+		}
+		if similarity == 1 {
+			break
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return 0, 0, false, err
+	}
+	if bestSimilarity < quoteDuplicateThreshold {
+		return 0, 0, false, nil
+	}
+	return bestId, bestSimilarity, true, nil
+}
+
+func saveQuoteDraft(channel string, q Quote) (int64, error) {
+	now := time.Now()
+	if _, err := statsDB.Exec("DELETE FROM quote_drafts WHERE created_at < ?", now.Add(-quoteDraftTTL).Unix()); err != nil {
+		return 0, err
+	}
+	res, err := statsDB.Exec(`
+		INSERT INTO quote_drafts(chat, quote_date, added_by, author, text, tags, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, channel, q.createdAt, q.addedBy, q.author, q.quote, strings.Join(q.tags, " "), now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func loadQuoteDraft(id int64) (string, Quote, bool, error) {
+	var channel, tags string
+	var q Quote
+	err := statsDB.QueryRow("SELECT chat, quote_date, added_by, author, text, tags FROM quote_drafts WHERE id = ?", id).Scan(&channel, &q.createdAt, &q.addedBy, &q.author, &q.quote, &tags)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", Quote{}, false, nil
+		}
+		return "", Quote{}, false, err
+	}
+	q.tags = strings.Fields(tags)
+	return channel, q, true, nil
+}
+
+func quoteDraftKeyboard(draftID int64) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "Всё равно добавить", CallbackData: fmt.Sprintf("%s%d:1", quoteDraftPrefix, draftID)},
+				{Text: "Отмена", CallbackData: fmt.Sprintf("%s%d:0", quoteDraftPrefix, draftID)},
+			},
+		},
+	}
+}
+
+// askDuplicateQuote checks the new quote against the base and, when a likely
+// duplicate exists, keeps the quote as a draft and asks for confirmation.
+// It returns true when the quote must not be saved right away.
+func askDuplicateQuote(ctx context.Context, b *bot.Bot, update *models.Update, channel string, q Quote) bool {
+	duplicateId, similarity, found, err := findDuplicateQuote(channel, q.quote)
+	if err != nil {
+		log.Println("Can't check quote duplicates")
+		log.Println(err)
+		return false
+	}
+	if !found {
+		return false
+	}
+
+	duplicate, _, err := loadQuote(channel, duplicateId)
+	if err != nil {
+		log.Println("Can't load duplicate quote")
+		log.Println(err)
+		return false
+	}
+
+	draftID, err := saveQuoteDraft(channel, q)
+	if err != nil {
+		log.Println("Can't save quote draft")
+		log.Println(err)
+		return false
+	}
+
+	text := fmt.Sprintf("Похожая цитата уже есть под номером %d (совпадение %.0f%%):\n%sВсё равно добавить?", duplicateId, similarity*100, formatQuotes([]Quote{duplicate}))
+	params := &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        text,
+		ReplyMarkup: quoteDraftKeyboard(draftID),
+	}
+	if update.Message.MessageThreadID != 0 {
+		params.MessageThreadID = update.Message.MessageThreadID
+	}
+	if _, err = b.SendMessage(ctx, params); err != nil {
+		log.Println("Can't ask about duplicate quote")
+		log.Println(err)
+	}
+	return true
+}
+
+func parseQuoteDraftAnswer(data string) (int64, bool, bool) {
+	parts := strings.Split(strings.TrimPrefix(data, quoteDraftPrefix), ":")
+	if len(parts) != 2 || (parts[1] != "0" && parts[1] != "1") {
+		return 0, false, false
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, false, false
+	}
+	return id, parts[1] == "1", true
+}
+
+func handleQuoteDraft(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle duplicate quote confirmation")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	msg := update.CallbackQuery.Message.Message
+	if msg == nil {
+		answerCallback(ctx, b, update, "Сообщение слишком старое")
+		return
+	}
+
+	draftID, confirmed, ok := parseQuoteDraftAnswer(update.CallbackQuery.Data)
+	if !ok {
+		answerCallback(ctx, b, update, "Странный ответ")
+		return
+	}
+
+	channel, q, found, err := loadQuoteDraft(draftID)
+	if err != nil {
+		log.Println("Can't load quote draft")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if !found || channel != getQuoteChannel(msg.Chat.ID) {
+		answerCallback(ctx, b, update, "Черновик устарел, добавь цитату заново")
+		return
+	}
+	if q.addedBy != update.CallbackQuery.From.ID {
+		answerCallback(ctx, b, update, "Решать может только тот, кто добавлял цитату")
+		return
+	}
+
+	if _, err = statsDB.Exec("DELETE FROM quote_drafts WHERE id = ?", draftID); err != nil {
+		log.Println("Can't delete quote draft")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+
+	text := "Добавление цитаты отменено"
+	if confirmed {
+		text, err = addQuote(ctx, b, msg.Chat.ID, channel, q)
+		if err != nil {
+			log.Println("Can't save quote")
+			log.Println(err)
+			answerCallback(ctx, b, update, "Что-то пошло не так и цитата не созранилась")
+			return
+		}
+	}
+
+	if _, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+	}); err != nil {
+		log.Println("Can't update duplicate quote message")
+		log.Println(err)
+	}
+	answerCallback(ctx, b, update, "")
+}
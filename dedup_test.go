@@ -0,0 +1,107 @@
+package main
+
+import (
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+func TestQuoteSimilarity(t *testing.T) {
+	tests := []struct {
+		a, b    string
+		similar bool
+	}{
+		{"Привет, мир!", "Привет, мир!", true},
+		{"Привет, мир!", "привет мир", true},
+		{"Ёжик в тумане", "ежик... в тумане!!!", true},
+		{"— Кто там? — Я!", "кто там я", true},
+		{"Кто не работает, тот ест", "Кто не работает, тот не ест", true},
+		{"Кто не работает, тот ест", "Кто работает, тот ест много и часто", false},
+		{"Кошка", "Собака", false},
+		{"", "", true},
+		{"", "!!!", true},
+	}
+	for _, test := range tests {
+		a, b := duplicateText(test.a), duplicateText(test.b)
+		similarity := quoteSimilarity(a, b)
+		if got := similarity >= quoteDuplicateThreshold; got != test.similar {
+			t.Errorf("quoteSimilarity(%q, %q) = %.2f, similar %v, want %v", test.a, test.b, similarity, got, test.similar)
+		}
+		if got := mayBeSimilar(a, countRunes(a), b); test.similar && !got {
+			t.Errorf("mayBeSimilar(%q, %q) = false for similar texts", test.a, test.b)
+		}
+	}
+}
+
+func TestMayBeSimilarLengthRatio(t *testing.T) {
+	base := []rune(strings.Repeat("а", 100))
+	tests := []struct {
+		length int
+		want   bool
+	}{
+		{100, true},
+		{85, true},
+		{84, false},
+		{117, true},
+		{118, false},
+		{0, false},
+	}
+	for _, test := range tests {
+		candidate := []rune(strings.Repeat("а", test.length))
+		if got := mayBeSimilar(base, countRunes(base), candidate); got != test.want {
+			t.Errorf("mayBeSimilar with lengths 100 and %d = %v, want %v", test.length, got, test.want)
+		}
+	}
+}
+
+// The prefilter may let through pairs that turn out different, but must never
+// drop a pair the edit distance calls similar.
+func TestMayBeSimilarKeepsSimilarPairs(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+	alphabet := []rune("абвгде ёжз")
+	randomText := func(length int) []rune {
+		text := make([]rune, length)
+		for i := range text {
+			text[i] = alphabet[random.Intn(len(alphabet))]
+		}
+		return text
+	}
+	edit := func(text []rune, edits int) []rune {
+		result := append([]rune(nil), text...)
+		for i := 0; i < edits; i++ {
+			pos := 0
+			if len(result) > 0 {
+				pos = random.Intn(len(result))
+			}
+			switch random.Intn(3) {
+			case 0:
+				result = append(result[:pos], append([]rune{alphabet[random.Intn(len(alphabet))]}, result[pos:]...)...)
+			case 1:
+				if len(result) > 0 {
+					result = append(result[:pos], result[pos+1:]...)
+				}
+			default:
+				if len(result) > 0 {
+					result[pos] = alphabet[random.Intn(len(alphabet))]
+				}
+			}
+		}
+		return result
+	}
+
+	checked := 0
+	for i := 0; i < 20000; i++ {
+		a := randomText(1 + random.Intn(40))
+		b := edit(a, random.Intn(6))
+		if quoteSimilarity(a, b) < quoteDuplicateThreshold {
+			continue
+		}
+		checked++
+		if !mayBeSimilar(a, countRunes(a), b) {
+			t.Fatalf("mayBeSimilar(%q, %q) = false, similarity %.3f", string(a), string(b), quoteSimilarity(a, b))
+		}
+	}
+	if checked == 0 {
+		t.Fatal("no similar pairs generated")
+	}
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const editTimeLayout = "2006-01-02 15:04:05"
+
+type messageVersion struct {
+	text     string
+	editedAt int64
+}
+
+// handleEditToStats keeps the previous text of an edited message in the
+// edit history and replaces the current text state. Like the rest of the
+// stats it works for groups only, private chats keep no history.
+func handleEditToStats(ctx context.Context, msg *models.Message) {
+	log.Println("Handle edited message to stats")
+	if msg.Chat.Type == "private" {
+		return
+	}
+
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	// The previous text was stored with the same policy, so the redacted
+	// versions compare fine.
+	text := storedMessageText(msg.Chat.ID, getMessageText(msg))
+	if text == "" {
+		return
+	}
+
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Can't start edit transaction")
+		log.Println(err)
+		return
+	}
+
+	var previous string
+	err = tx.QueryRow("SELECT text FROM message_text_state WHERE chat_id = ? AND message_id = ?", msg.Chat.ID, msg.ID).Scan(&previous)
+	if err != nil && err != sql.ErrNoRows {
+		_ = tx.Rollback()
+		log.Println("Can't get previous message text")
+		log.Println(err)
+		return
+	}
+
+	if err == nil && previous != text {
+		editedAt := int64(msg.EditDate)
+		if editedAt == 0 {
+			editedAt = time.Now().Unix()
+		}
+		if _, err = tx.Exec("INSERT INTO message_edits(chat_id, message_id, text, edited_at) VALUES (?, ?, ?, ?)", msg.Chat.ID, msg.ID, previous, editedAt); err != nil {
+			_ = tx.Rollback()
+			log.Println("Can't save message edit")
+			log.Println(err)
+			return
+		}
+	}
+
+	if err = upsertMessageTextState(tx, msg.Chat.ID, msg.ID, text, msg.Date); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't save message text state")
+		log.Println(err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Can't commit edit transaction")
+		log.Println(err)
+	}
+}
+
+func loadMessageVersions(chatID int64, messageID int) ([]messageVersion, error) {
+	rows, err := statsDB.Query("SELECT text, edited_at FROM message_edits WHERE chat_id = ? AND message_id = ? ORDER BY id", chatID, messageID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var versions []messageVersion
+	for rows.Next() {
+		var item messageVersion
+		if err = rows.Scan(&item.text, &item.editedAt); err != nil {
+			return nil, err
+		}
+		versions = append(versions, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return versions, nil
+}
+
+func formatEditTime(unixTime int64) string {
+	return time.Unix(unixTime, 0).In(time.Local).Format(editTimeLayout)
+}
+
+func handleEdits(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle message edits")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	if update.Message.Chat.Type == "private" {
+		sendText(ctx, b, update, "Правки сохраняются только в группах, в личке их история не ведётся")
+		return
+	}
+
+	reply := update.Message.ReplyToMessage
+	if reply == nil {
+		sendText(ctx, b, update, "Нужно ответить командой на сообщение")
+		return
+	}
+
+	versions, err := loadMessageVersions(update.Message.Chat.ID, reply.ID)
+	if err != nil {
+		log.Println("Can't load message edits")
+		log.Println(err)
+		return
+	}
+	if len(versions) == 0 {
+		sendText(ctx, b, update, "Правок этого сообщения не видел")
+		return
+	}
+
+	// Each version lived from the previous edit until its own replacement.
+	msg := "Прошлые версии сообщения:\n"
+	since := int64(reply.Date)
+	for i, version := range versions {
+		msg += fmt.Sprintf("%d. [%s] %s\n", i+1, formatEditTime(since), version.text)
+		since = version.editedAt
+	}
+	current := getMessageText(reply)
+	if current != "" {
+		msg += fmt.Sprintf("Сейчас [%s]: %s", formatEditTime(since), current)
+	}
+	sendText(ctx, b, update, msg)
+}
@@ -0,0 +1,432 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const maxQuotesImportSize = 20 * 1024 * 1024
+
+type quoteExportItem struct {
+	ID      int      `json:"id"`
+	Date    string   `json:"date"`
+	Author  string   `json:"author"`
+	AddedBy int64    `json:"added_by,omitempty"`
+	Text    string   `json:"text"`
+	Tags    []string `json:"tags,omitempty"`
+}
+
+type quoteImportResult struct {
+	added      int
+	duplicates int
+	similar    int
+	deleted    int
+	renumbered int
+}
+
+func isQuotesFormat(format string) bool {
+	return format == "json" || format == "csv" || format == "txt"
+}
+
+// quotesFormatFromFileName guesses the import format by the file extension,
+// everything unknown is treated as the legacy text format.
+func quotesFormatFromFileName(name string) string {
+	format := strings.TrimPrefix(strings.ToLower(filepath.Ext(name)), ".")
+	if isQuotesFormat(format) {
+		return format
+	}
+	return "txt"
+}
+
+func formatExportDate(createdAt int64) string {
+	if createdAt == 0 {
+		return ""
+	}
+	return time.Unix(createdAt, 0).In(time.Local).Format(time.RFC3339)
+}
+
+func parseExportDate(date string) int64 {
+	if date == "" {
+		return 0
+	}
+	if t, err := time.Parse(time.RFC3339, date); err == nil {
+		return t.Unix()
+	}
+	return parseQuoteDate(date)
+}
+
+func writeQuotesExport(w io.Writer, channel string, format string, quotes []Quote) error {
+	switch format {
+	case "json":
+		items := make([]quoteExportItem, 0, len(quotes))
+		for _, q := range quotes {
+			items = append(items, quoteExportItem{
+				ID:      q.id,
+				Date:    formatExportDate(q.createdAt),
+				Author:  q.author,
+				AddedBy: q.addedBy,
+				Text:    q.quote,
+				Tags:    q.tags,
+			})
+		}
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(items)
+	case "csv":
+		writer := csv.NewWriter(w)
+		if err := writer.Write([]string{"id", "date", "author", "added_by", "text", "tags"}); err != nil {
+			return err
+		}
+		for _, q := range quotes {
+			if err := writer.Write([]string{
+				strconv.Itoa(q.id),
+				formatExportDate(q.createdAt),
+				q.author,
+				strconv.FormatInt(q.addedBy, 10),
+				q.quote,
+				strings.Join(q.tags, ";"),
+			}); err != nil {
+				return err
+			}
+		}
+		writer.Flush()
+		return writer.Error()
+	case "txt":
+		// The legacy format is space separated, one quote per line.
+		for _, q := range quotes {
+			date := "00/00/0000 00:00:00"
+			if q.createdAt != 0 {
+				date = formatQuoteDate(q.createdAt)
+			}
+			author := strings.Join(strings.Fields(q.author), "_")
+			if author == "" {
+				author = "-"
+			}
+			text := strings.ReplaceAll(q.quote, "\n", " | ")
+			if _, err := fmt.Fprintf(w, "%d %s %s %s %s\n", q.id, date, channel, author, text); err != nil {
+				return err
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown quotes format %s", format)
+	}
+}
+
+// normalizeImportTags keeps the valid tags of an imported quote, the same
+// way !aq takes them.
+func normalizeImportTags(tags []string) []string {
+	var result []string
+	for _, tag := range tags {
+		if tag = normalizeQuoteTag(strings.TrimSpace(tag)); tag != "" {
+			result = append(result, tag)
+		}
+	}
+	return result
+}
+
+func readQuotesImport(r io.Reader, format string) ([]Quote, error) {
+	switch format {
+	case "json":
+		var items []quoteExportItem
+		if err := json.NewDecoder(r).Decode(&items); err != nil {
+			return nil, err
+		}
+		quotes := make([]Quote, 0, len(items))
+		for _, item := range items {
+			quotes = append(quotes, Quote{
+				id:        item.ID,
+				author:    item.Author,
+				addedBy:   item.AddedBy,
+				quote:     item.Text,
+				createdAt: parseExportDate(item.Date),
+				tags:      normalizeImportTags(item.Tags),
+			})
+		}
+		return quotes, nil
+	case "csv":
+		reader := csv.NewReader(r)
+		// Exports made before tags have one column less.
+		reader.FieldsPerRecord = -1
+		records, err := reader.ReadAll()
+		if err != nil {
+			return nil, err
+		}
+		var quotes []Quote
+		for i, record := range records {
+			if len(record) < 5 {
+				return nil, fmt.Errorf("line %d: expected 5 columns, got %d", i+1, len(record))
+			}
+			id, err := strconv.Atoi(record[0])
+			if err != nil {
+				if i == 0 {
+					// header
+					continue
+				}
+				return nil, fmt.Errorf("line %d: bad quote id %s", i+1, record[0])
+			}
+			addedBy, _ := strconv.ParseInt(record[3], 10, 64)
+			var tags []string
+			if len(record) > 5 {
+				tags = normalizeImportTags(strings.Split(record[5], ";"))
+			}
+			quotes = append(quotes, Quote{
+				id:        id,
+				createdAt: parseExportDate(record[1]),
+				author:    record[2],
+				addedBy:   addedBy,
+				quote:     record[4],
+				tags:      tags,
+			})
+		}
+		return quotes, nil
+	case "txt":
+		return parseLegacyQuotes(r)
+	default:
+		return nil, fmt.Errorf("unknown quotes format %s", format)
+	}
+}
+
+// importQuotes adds quotes to the channel skipping texts already present.
+// Quotes similar to the existing ones are skipped like in !aq, texts of
+// deleted quotes are skipped too and counted apart, so they can be restored
+// with !uq. Original IDs are kept when free, otherwise the quote gets the
+// next free ID.
+func importQuotes(ctx context.Context, channel string, quotes []Quote) (quoteImportResult, error) {
+	var result quoteImportResult
+
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		return result, err
+	}
+
+	rows, err := tx.Query("SELECT quote_id, text, search_text, deleted_at FROM quotes WHERE chat = ?", channel)
+	if err != nil {
+		_ = tx.Rollback()
+		return result, err
+	}
+	usedIds := make(map[int]bool)
+	knownTexts := make(map[string]bool)
+	deletedTexts := make(map[string]bool)
+	var knownQuotes [][]rune
+	maxId := 0
+	for rows.Next() {
+		var id int
+		var text, searchText string
+		var deletedAt int64
+		if err = rows.Scan(&id, &text, &searchText, &deletedAt); err != nil {
+			rows.Close()
+			_ = tx.Rollback()
+			return result, err
+		}
+		usedIds[id] = true
+		if deletedAt != 0 {
+			deletedTexts[searchText] = true
+		} else {
+			knownTexts[searchText] = true
+			knownQuotes = append(knownQuotes, duplicateText(text))
+		}
+		if id > maxId {
+			maxId = id
+		}
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		_ = tx.Rollback()
+		return result, err
+	}
+	rows.Close()
+
+	for _, q := range quotes {
+		searchText := normalizeSearchText(strings.TrimSpace(q.quote))
+		if searchText == "" || knownTexts[searchText] {
+			result.duplicates++
+			continue
+		}
+		if deletedTexts[searchText] {
+			result.deleted++
+			continue
+		}
+		text := duplicateText(q.quote)
+		if hasSimilarQuote(knownQuotes, text) {
+			result.similar++
+			continue
+		}
+
+		id := q.id
+		if id <= 0 || usedIds[id] {
+			id = maxId + 1
+			result.renumbered++
+		}
+
+		if _, err = tx.Exec(`
+			INSERT INTO quotes(chat, quote_id, created_at, added_by, author, text, search_text)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`, channel, id, q.createdAt, q.addedBy, q.author, q.quote, searchText); err != nil {
+			_ = tx.Rollback()
+			return result, err
+		}
+		if err = saveQuoteTags(tx, channel, id, q.tags); err != nil {
+			_ = tx.Rollback()
+			return result, err
+		}
+
+		usedIds[id] = true
+		knownTexts[searchText] = true
+		knownQuotes = append(knownQuotes, text)
+		if id > maxId {
+			maxId = id
+		}
+		result.added++
+	}
+
+	if err = tx.Commit(); err != nil {
+		return quoteImportResult{}, err
+	}
+	return result, nil
+}
+
+func handleExportQ(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quotes export")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	format := "json"
+	parts := strings.Fields(update.Message.Text)
+	if len(parts) > 1 {
+		format = strings.ToLower(parts[1])
+	}
+	if !isQuotesFormat(format) {
+		sendText(ctx, b, update, "Формат выгрузки может быть json, csv или txt")
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	quotes, err := loadQuotes("chat = ? ORDER BY quote_id", channel)
+	if err != nil {
+		log.Println("Can't load quotes for export")
+		log.Println(err)
+		return
+	}
+	if len(quotes) == 0 {
+		sendText(ctx, b, update, "В этом чате пока нет цитат")
+		return
+	}
+
+	var buf bytes.Buffer
+	if err = writeQuotesExport(&buf, channel, format, quotes); err != nil {
+		log.Println("Can't export quotes")
+		log.Println(err)
+		return
+	}
+
+	params := &bot.SendDocumentParams{
+		ChatID: update.Message.Chat.ID,
+		Document: &models.InputFileUpload{
+			Filename: "quotes." + format,
+			Data:     &buf,
+		},
+		Caption: fmt.Sprintf("Цитат: %d", len(quotes)),
+	}
+	if update.Message.MessageThreadID != 0 {
+		params.MessageThreadID = update.Message.MessageThreadID
+	}
+	if _, err = b.SendDocument(ctx, params); err != nil {
+		log.Println("Can't send quotes export")
+		log.Println(err)
+	}
+}
+
+func downloadTelegramFile(ctx context.Context, b *bot.Bot, fileID string) ([]byte, error) {
+	file, err := b.GetFile(ctx, &bot.GetFileParams{FileID: fileID})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.FileDownloadLink(file), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxQuotesImportSize))
+}
+
+func handleImportQ(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quotes import")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	reply := update.Message.ReplyToMessage
+	if reply == nil || reply.Document == nil {
+		sendText(ctx, b, update, "Нужно ответить командой на файл с цитатами (json, csv или txt)")
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	if update.Message.From == nil || !isQuoteChannelAdmin(ctx, b, channel, update.Message.From.ID) {
+		sendText(ctx, b, update, "Загружать цитаты могут только администраторы чата")
+		return
+	}
+
+	moderated, err := isQuoteModerationEnabled(update.Message.Chat.ID)
+	if err != nil {
+		log.Println("Can't check quote moderation")
+		log.Println(err)
+		sendText(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if moderated {
+		sendText(ctx, b, update, "В чате включена модерация цитат, загрузка файла обошла бы её. Выключи модерацию (!modq off), загрузи файл и включи её снова")
+		return
+	}
+
+	data, err := downloadTelegramFile(ctx, b, reply.Document.FileID)
+	if err != nil {
+		log.Println("Can't download quotes file")
+		log.Println(err)
+		sendText(ctx, b, update, "Не получилось скачать файл")
+		return
+	}
+
+	quotes, err := readQuotesImport(bytes.NewReader(data), quotesFormatFromFileName(reply.Document.FileName))
+	if err != nil {
+		log.Println("Can't parse quotes file")
+		log.Println(err)
+		sendText(ctx, b, update, "Не получилось разобрать файл: "+err.Error())
+		return
+	}
+
+	result, err := importQuotes(ctx, channel, quotes)
+	if err != nil {
+		log.Println("Can't import quotes")
+		log.Println(err)
+		sendText(ctx, b, update, "Что-то пошло не так и цитаты не загрузились")
+		return
+	}
+
+	sendText(ctx, b, update, fmt.Sprintf("Добавлено цитат: %d\nДубликатов пропущено: %d\nПохожих пропущено: %d\nРанее удалённых пропущено (вернуть можно через !uq): %d\nПолучили новый номер: %d", result.added, result.duplicates, result.similar, result.deleted, result.renumbered))
+}
@@ -6,3 +6,8 @@ require (
 	github.com/go-telegram/bot v1.18.0
 	github.com/mattn/go-sqlite3 v1.14.22
 )
+
+require (
+	golang.org/x/image v0.15.0
+	golang.org/x/text v0.14.0 // indirect
+)
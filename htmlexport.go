@@ -0,0 +1,401 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"hash/fnv"
+	"html/template"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const htmlExportSnippet = 80
+
+var htmlMonthNames = [...]string{"Январь", "Февраль", "Март", "Апрель", "Май", "Июнь", "Июль", "Август", "Сентябрь", "Октябрь", "Ноябрь", "Декабрь"}
+
+type htmlMessage struct {
+	Anchor    string
+	Time      string
+	Author    string
+	Color     template.CSS
+	Text      string
+	Edited    bool
+	ReplyLink string
+	ReplyText string
+}
+
+type htmlDay struct {
+	Title    string
+	Day      string
+	Prev     string
+	Next     string
+	Messages []htmlMessage
+}
+
+type htmlCalendarDay struct {
+	Day   int
+	Link  string
+	Count int
+}
+
+type htmlMonth struct {
+	Name  string
+	Weeks [][]htmlCalendarDay
+}
+
+type htmlIndex struct {
+	Title    string
+	Days     int
+	Messages int
+	Months   []htmlMonth
+}
+
+// htmlReplyTarget is where a reply link points, replies often go to
+// messages of earlier days.
+type htmlReplyTarget struct {
+	link   string
+	author string
+	text   string
+}
+
+var htmlDayTemplate = template.Must(template.New("day").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}, {{.Day}}</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<nav><a href="index.html">Календарь</a>{{if .Prev}} · <a href="{{.Prev}}.html">← {{.Prev}}</a>{{end}}{{if .Next}} · <a href="{{.Next}}.html">{{.Next}} →</a>{{end}}</nav>
+<h1>{{.Title}}, {{.Day}}</h1>
+{{range .Messages}}<div class="msg" id="{{.Anchor}}">
+<a class="time" href="#{{.Anchor}}">{{.Time}}</a> <span class="author" style="{{.Color}}">{{.Author}}</span>{{if .Edited}} <span class="edited">изменено</span>{{end}}
+{{if .ReplyLink}}<a class="reply" href="{{.ReplyLink}}">↩ {{.ReplyText}}</a>
+{{end}}<div class="text">{{.Text}}</div>
+</div>
+{{end}}</body>
+</html>
+`))
+
+var htmlIndexTemplate = template.Must(template.New("index").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>{{.Title}}</title>
+<link rel="stylesheet" href="style.css">
+</head>
+<body>
+<h1>{{.Title}}</h1>
+<p>Дней: {{.Days}}, сообщений: {{.Messages}}</p>
+<input id="q" type="search" placeholder="Поиск по архиву" autofocus>
+<ol id="results"></ol>
+{{range .Months}}<table class="month">
+<caption>{{.Name}}</caption>
+<tr><th>Пн</th><th>Вт</th><th>Ср</th><th>Чт</th><th>Пт</th><th>Сб</th><th>Вс</th></tr>
+{{range .Weeks}}<tr>{{range .}}<td>{{if .Link}}<a href="{{.Link}}" title="Сообщений: {{.Count}}">{{.Day}}</a>{{else if .Day}}{{.Day}}{{end}}</td>{{end}}</tr>
+{{end}}</table>
+{{end}}<script src="search-index.js"></script>
+<script src="search.js"></script>
+</body>
+</html>
+`))
+
+const htmlExportStyle = `body { font-family: sans-serif; max-width: 60em; margin: 1em auto; padding: 0 1em; color: #222; }
+nav { margin-bottom: 1em; }
+.msg { padding: 0.3em 0; border-bottom: 1px solid #eee; }
+.msg:target { background: #fff5c0; }
+.time { color: #888; text-decoration: none; font-size: 0.85em; }
+.author { font-weight: bold; }
+.edited { color: #888; font-size: 0.8em; }
+.reply { display: block; color: #666; font-size: 0.85em; border-left: 2px solid #ccc; padding-left: 0.5em; text-decoration: none; }
+.text { white-space: pre-wrap; overflow-wrap: anywhere; }
+.month { display: inline-table; margin: 0 1em 1em 0; border-collapse: collapse; vertical-align: top; }
+.month caption { font-weight: bold; }
+.month td, .month th { width: 2em; text-align: center; padding: 0.2em; color: #bbb; }
+.month td a { color: #06c; font-weight: bold; }
+#q { width: 100%; font-size: 1.1em; padding: 0.3em; box-sizing: border-box; }
+`
+
+const htmlExportSearch = `(function () {
+	var input = document.getElementById("q");
+	var results = document.getElementById("results");
+	var limit = 200;
+
+	function normalize(text) {
+		return text.toLowerCase().replace(/ё/g, "е");
+	}
+
+	var prepared = searchIndex.map(function (entry) {
+		return normalize(entry[3] + " " + entry[4]);
+	});
+
+	input.addEventListener("input", function () {
+		var words = normalize(input.value).split(/\s+/).filter(Boolean);
+		results.textContent = "";
+		if (words.join("").length < 2) {
+			return;
+		}
+
+		var found = 0;
+		for (var i = prepared.length - 1; i >= 0 && found < limit; i--) {
+			var text = prepared[i];
+			if (!words.every(function (word) { return text.indexOf(word) >= 0; })) {
+				continue;
+			}
+			var entry = searchIndex[i];
+			var item = document.createElement("li");
+			var link = document.createElement("a");
+			link.href = entry[0] + ".html#" + entry[1];
+			link.textContent = entry[0] + " " + entry[2];
+			item.appendChild(link);
+			item.appendChild(document.createTextNode(" " + entry[3] + ": " + entry[4].slice(0, 200)));
+			results.appendChild(item);
+			found++;
+		}
+		if (found === 0) {
+			results.textContent = "Ничего не нашлось";
+		}
+	});
+})();
+`
+
+// userColor gives every author a stable colour, the same person keeps it on
+// all pages.
+func userColor(message logMessage) template.CSS {
+	key := message.author
+	if message.userID != 0 {
+		key = strconv.FormatInt(message.userID, 10)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	return template.CSS(fmt.Sprintf("color: hsl(%d, 60%%, 35%%)", h.Sum32()%360))
+}
+
+func shortenText(text string, limit int) string {
+	if runes := []rune(text); len(runes) > limit {
+		return string(runes[:limit]) + "…"
+	}
+	return text
+}
+
+// mergeLogEdits folds the edits into the messages they change, an edit of a
+// message from another day stays a separate entry.
+func mergeLogEdits(messages []logMessage) ([]logMessage, []bool) {
+	var merged []logMessage
+	var edited []bool
+	byID := make(map[int]int)
+	for _, message := range messages {
+		index, exists := byID[message.messageID]
+		if message.messageID != 0 && exists {
+			if !message.editTime.IsZero() {
+				merged[index].text = message.text
+				edited[index] = true
+			}
+			continue
+		}
+		if message.messageID != 0 {
+			byID[message.messageID] = len(merged)
+		}
+		merged = append(merged, message)
+		edited = append(edited, !message.editTime.IsZero())
+	}
+	return merged, edited
+}
+
+func buildHTMLCalendar(days []string, counts map[string]int) []htmlMonth {
+	var months []htmlMonth
+	for i := len(days) - 1; i >= 0; {
+		day, _ := parseSearchDay(days[i])
+		first := time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.Local)
+		month := htmlMonth{Name: fmt.Sprintf("%s %d", htmlMonthNames[day.Month()-1], day.Year())}
+
+		var cells []htmlCalendarDay
+		for offset := (int(first.Weekday()) + 6) % 7; offset > 0; offset-- {
+			cells = append(cells, htmlCalendarDay{})
+		}
+		for date := first; date.Month() == first.Month(); date = date.AddDate(0, 0, 1) {
+			cell := htmlCalendarDay{Day: date.Day()}
+			if count, exists := counts[date.Format(dayLayout)]; exists {
+				cell.Link = date.Format(dayLayout) + ".html"
+				cell.Count = count
+			}
+			cells = append(cells, cell)
+		}
+		for len(cells)%7 != 0 {
+			cells = append(cells, htmlCalendarDay{})
+		}
+		for start := 0; start < len(cells); start += 7 {
+			month.Weeks = append(month.Weeks, cells[start:start+7])
+		}
+		months = append(months, month)
+
+		// Skip the rest of the days of this month.
+		for i >= 0 && days[i] >= first.Format(dayLayout) {
+			i--
+		}
+	}
+	return months
+}
+
+func writeHTMLFile(path string, tmpl *template.Template, data any) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err = tmpl.Execute(f, data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func exportChatHTML(chatID int64, title string, output string) (int, int, error) {
+	logDays, err := listLogDays(chatID)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(logDays) == 0 {
+		return 0, 0, fmt.Errorf("no logs of chat %d", chatID)
+	}
+
+	// Days go oldest first, replies point back in time.
+	var days []string
+	messagesByDay := make(map[string][]logMessage)
+	editedByDay := make(map[string][]bool)
+	replyTargets := make(map[int]htmlReplyTarget)
+	for i := len(logDays) - 1; i >= 0; i-- {
+		messages, err := readLogMessages(chatID, logDays[i])
+		if err != nil {
+			return 0, 0, err
+		}
+		if len(messages) == 0 {
+			continue
+		}
+		day := logDays[i].Format(dayLayout)
+		messages, edited := mergeLogEdits(messages)
+		days = append(days, day)
+		messagesByDay[day] = messages
+		editedByDay[day] = edited
+		for _, message := range messages {
+			if message.messageID != 0 {
+				replyTargets[message.messageID] = htmlReplyTarget{
+					link:   fmt.Sprintf("%s.html#m%d", day, message.messageID),
+					author: message.author,
+					text:   shortenText(message.text, htmlExportSnippet),
+				}
+			}
+		}
+	}
+
+	if err = os.MkdirAll(output, 0755); err != nil {
+		return 0, 0, err
+	}
+
+	var searchIndex [][]string
+	counts := make(map[string]int)
+	total := 0
+	for i, day := range days {
+		page := htmlDay{Title: title, Day: day}
+		if i > 0 {
+			page.Prev = days[i-1]
+		}
+		if i < len(days)-1 {
+			page.Next = days[i+1]
+		}
+
+		for j, message := range messagesByDay[day] {
+			anchor := fmt.Sprintf("n%d", j+1)
+			if message.messageID != 0 {
+				anchor = fmt.Sprintf("m%d", message.messageID)
+			}
+			item := htmlMessage{
+				Anchor: anchor,
+				Time:   message.time.In(time.Local).Format(logTimeLayout),
+				Author: message.author,
+				Color:  userColor(message),
+				Text:   message.text,
+				Edited: editedByDay[day][j],
+			}
+			if target, exists := replyTargets[message.replyToID]; exists && message.replyToID != 0 {
+				item.ReplyLink = target.link
+				item.ReplyText = target.author + ": " + target.text
+			}
+			page.Messages = append(page.Messages, item)
+			searchIndex = append(searchIndex, []string{day, anchor, item.Time, item.Author, item.Text})
+		}
+		counts[day] = len(page.Messages)
+		total += len(page.Messages)
+
+		if err = writeHTMLFile(filepath.Join(output, day+".html"), htmlDayTemplate, page); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	index := htmlIndex{Title: title, Days: len(days), Messages: total, Months: buildHTMLCalendar(days, counts)}
+	if err = writeHTMLFile(filepath.Join(output, "index.html"), htmlIndexTemplate, index); err != nil {
+		return 0, 0, err
+	}
+
+	data, err := json.Marshal(searchIndex)
+	if err != nil {
+		return 0, 0, err
+	}
+	if err = os.WriteFile(filepath.Join(output, "search-index.js"), []byte("var searchIndex = "+string(data)+";\n"), 0644); err != nil {
+		return 0, 0, err
+	}
+	if err = os.WriteFile(filepath.Join(output, "search.js"), []byte(htmlExportSearch), 0644); err != nil {
+		return 0, 0, err
+	}
+	if err = os.WriteFile(filepath.Join(output, "style.css"), []byte(htmlExportStyle), 0644); err != nil {
+		return 0, 0, err
+	}
+	return len(days), total, nil
+}
+
+func runExportHTML(args []string) int {
+	flags := flag.NewFlagSet("export-html", flag.ExitOnError)
+	chatID := flags.Int64("chat", 0, "chat ID whose logs are exported")
+	title := flags.String("title", "", "archive title, the last known chat name by default")
+	output := flags.String("o", "", "output directory, html-<chat_id> by default")
+	flags.Parse(args)
+
+	if *chatID == 0 {
+		log.Println("Chat is required, pass -chat")
+		return 2
+	}
+	if *output == "" {
+		*output = fmt.Sprintf("html-%d", *chatID)
+	}
+
+	if *title == "" {
+		root, err := getLogsRoot()
+		if err != nil {
+			log.Println("Can't get logs directory")
+			log.Println(err)
+			return 1
+		}
+		if *title, err = latestLogAlias(root, *chatID); err != nil {
+			log.Println("Can't load log aliases")
+			log.Println(err)
+			return 1
+		}
+		if *title == "" {
+			*title = fmt.Sprintf("Чат %d", *chatID)
+		}
+	}
+
+	days, messages, err := exportChatHTML(*chatID, *title, *output)
+	if err != nil {
+		log.Println("Can't export chat logs to html")
+		log.Println(err)
+		return 1
+	}
+	log.Printf("Exported %d days and %d messages of chat %d to %s", days, messages, *chatID, *output)
+	return 0
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	inlineQuoteResultsLimit = 20
+	inlineMembershipTTL     = 10 * time.Minute
+)
+
+type inlineMembership struct {
+	member    bool
+	checkedAt time.Time
+}
+
+var inlineMembershipCache sync.Map
+
+func inlineMembershipKey(chatID int64, userID int64) string {
+	return fmt.Sprintf("%d:%d", chatID, userID)
+}
+
+// isChatMember checks the user is still in the chat, answers are cached for a
+// while so typing an inline query doesn't hit getChatMember on every key.
+func isChatMember(ctx context.Context, b *bot.Bot, chatID int64, userID int64) bool {
+	key := inlineMembershipKey(chatID, userID)
+	if cached, exists := inlineMembershipCache.Load(key); exists {
+		item := cached.(inlineMembership)
+		if time.Since(item.checkedAt) < inlineMembershipTTL {
+			return item.member
+		}
+	}
+
+	member, err := b.GetChatMember(ctx, &bot.GetChatMemberParams{
+		ChatID: chatID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Printf("Can't get chat %d member status for inline query", chatID)
+		log.Println(err)
+		return false
+	}
+
+	isMember := false
+	switch member.Type {
+	case models.ChatMemberTypeOwner, models.ChatMemberTypeAdministrator, models.ChatMemberTypeMember:
+		isMember = true
+	case models.ChatMemberTypeRestricted:
+		isMember = member.Restricted != nil && member.Restricted.IsMember
+	}
+
+	inlineMembershipCache.Store(key, inlineMembership{member: isMember, checkedAt: time.Now()})
+	return isMember
+}
+
+// getUserQuoteChannels returns quote bases of the chats where the user has
+// written something and is still a member.
+func getUserQuoteChannels(ctx context.Context, b *bot.Bot, userID int64) ([]string, error) {
+	rows, err := statsDB.Query("SELECT DISTINCT chat_id FROM stats_total WHERE user_id = ?", userID)
+	if err != nil {
+		return nil, err
+	}
+	var chatIDs []int64
+	for rows.Next() {
+		var chatID int64
+		if err = rows.Scan(&chatID); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		chatIDs = append(chatIDs, chatID)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return nil, err
+	}
+	rows.Close()
+
+	seen := make(map[string]bool)
+	var channels []string
+	for _, chatID := range chatIDs {
+		if !isChatMember(ctx, b, chatID, userID) {
+			continue
+		}
+		channel := getQuoteChannel(chatID)
+		if seen[channel] {
+			continue
+		}
+		seen[channel] = true
+		channels = append(channels, channel)
+	}
+	return channels, nil
+}
+
+func inlineQuoteResult(channel string, q Quote) models.InlineQueryResult {
+	description := q.quote
+	if runes := []rune(description); len(runes) > 100 {
+		description = string(runes[:100]) + "…"
+	}
+	return &models.InlineQueryResultArticle{
+		ID:          fmt.Sprintf("%s:%d", channel, q.id),
+		Title:       fmt.Sprintf("[%d] %s", q.id, q.author),
+		Description: description,
+		InputMessageContent: &models.InputTextMessageContent{
+			MessageText: formatQuotes([]Quote{q}),
+		},
+	}
+}
+
+func handleInlineQuery(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle inline query")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	inlineQuery := update.InlineQuery
+	if inlineQuery.From == nil {
+		return
+	}
+
+	channels, err := getUserQuoteChannels(ctx, b, inlineQuery.From.ID)
+	if err != nil {
+		log.Println("Can't get quote channels for inline query")
+		log.Println(err)
+		return
+	}
+
+	query := parseSearchQuery(inlineQuery.Query)
+	type channelQuote struct {
+		channel string
+		quote   Quote
+	}
+	var found []channelQuote
+	for _, channel := range channels {
+		var quotes []Quote
+		if query.isEmpty() {
+			quotes, err = loadQuotes("chat = ? ORDER BY RANDOM() LIMIT ?", channel, inlineQuoteResultsLimit)
+		} else {
+			quotes, err = searchQuotes(channel, query)
+		}
+		if err != nil {
+			log.Println("Can't search quotes for inline query")
+			log.Println(err)
+			return
+		}
+		for _, q := range quotes {
+			found = append(found, channelQuote{channel: channel, quote: q})
+		}
+	}
+
+	// An offset past the end means the client already has everything, the
+	// empty answer without NextOffset stops the paging.
+	offset, _ := strconv.Atoi(inlineQuery.Offset)
+	if offset < 0 {
+		offset = 0
+	}
+	if offset > len(found) {
+		offset = len(found)
+	}
+	end := offset + inlineQuoteResultsLimit
+	if end > len(found) {
+		end = len(found)
+	}
+
+	results := make([]models.InlineQueryResult, 0, end-offset)
+	for _, item := range found[offset:end] {
+		results = append(results, inlineQuoteResult(item.channel, item.quote))
+	}
+
+	params := &bot.AnswerInlineQueryParams{
+		InlineQueryID: inlineQuery.ID,
+		Results:       results,
+		CacheTime:     30,
+		IsPersonal:    true,
+	}
+	if end < len(found) && !query.isEmpty() {
+		params.NextOffset = strconv.Itoa(end)
+	}
+	if _, err = b.AnswerInlineQuery(ctx, params); err != nil {
+		log.Println("Can't answer inline query")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,161 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	logFormatText  = "text"
+	logFormatJSONL = "jsonl"
+	logFormatBoth  = "both"
+)
+
+// chatLogFormat is set from LOG_FORMAT, the plain text log stays the default.
+var chatLogFormat = logFormatText
+
+func isChatLogFormat(format string) bool {
+	return format == logFormatText || format == logFormatJSONL || format == logFormatBoth
+}
+
+func writesTextLogs() bool {
+	return chatLogFormat == logFormatText || chatLogFormat == logFormatBoth
+}
+
+func writesJSONLogs() bool {
+	return chatLogFormat == logFormatJSONL || chatLogFormat == logFormatBoth
+}
+
+type chatLogMedia struct {
+	Type      string `json:"type"`
+	FileID    string `json:"file_id,omitempty"`
+	FileName  string `json:"file_name,omitempty"`
+	MimeType  string `json:"mime_type,omitempty"`
+	Duration  int    `json:"duration,omitempty"`
+	Emoji     string `json:"emoji,omitempty"`
+	Performer string `json:"performer,omitempty"`
+	Title     string `json:"title,omitempty"`
+}
+
+// chatLogRecord is one line of the JSONL chat log. Edits repeat the message
+// ID and time of the original message and carry the edit time.
+type chatLogRecord struct {
+	Time             string        `json:"time"`
+	EditTime         string        `json:"edit_time,omitempty"`
+	ChatID           int64         `json:"chat_id"`
+	ChatType         string        `json:"chat_type"`
+	ChatTitle        string        `json:"chat_title,omitempty"`
+	MessageID        int           `json:"message_id"`
+	Kind             string        `json:"kind"`
+	ThreadID         int           `json:"thread_id,omitempty"`
+	UserID           int64         `json:"user_id,omitempty"`
+	Username         string        `json:"username,omitempty"`
+	Name             string        `json:"name,omitempty"`
+	SenderChatID     int64         `json:"sender_chat_id,omitempty"`
+	Text             string        `json:"text,omitempty"`
+	Caption          string        `json:"caption,omitempty"`
+	Summary          string        `json:"summary,omitempty"`
+	ReplyToMessageID int           `json:"reply_to_message_id,omitempty"`
+	ForwardFrom      string        `json:"forward_from,omitempty"`
+	ForwardFromKey   string        `json:"forward_from_key,omitempty"`
+	ForwardDate      string        `json:"forward_date,omitempty"`
+	ViaBot           string        `json:"via_bot,omitempty"`
+	MediaGroupID     string        `json:"media_group_id,omitempty"`
+	Media            *chatLogMedia `json:"media,omitempty"`
+}
+
+func formatLogTime(unixTime int) string {
+	return time.Unix(int64(unixTime), 0).In(time.Local).Format(time.RFC3339)
+}
+
+func getForwardDate(origin *models.MessageOrigin) int {
+	switch {
+	case origin.MessageOriginUser != nil:
+		return origin.MessageOriginUser.Date
+	case origin.MessageOriginHiddenUser != nil:
+		return origin.MessageOriginHiddenUser.Date
+	case origin.MessageOriginChat != nil:
+		return origin.MessageOriginChat.Date
+	case origin.MessageOriginChannel != nil:
+		return origin.MessageOriginChannel.Date
+	}
+	return 0
+}
+
+func getMessageMedia(msg *models.Message) *chatLogMedia {
+	switch {
+	case len(msg.Photo) > 0:
+		return &chatLogMedia{Type: "photo", FileID: msg.Photo[len(msg.Photo)-1].FileID}
+	case msg.Video != nil:
+		return &chatLogMedia{Type: "video", FileID: msg.Video.FileID, FileName: msg.Video.FileName, MimeType: msg.Video.MimeType, Duration: msg.Video.Duration}
+	case msg.Animation != nil:
+		return &chatLogMedia{Type: "animation", FileID: msg.Animation.FileID, FileName: msg.Animation.FileName, MimeType: msg.Animation.MimeType, Duration: msg.Animation.Duration}
+	case msg.Audio != nil:
+		return &chatLogMedia{Type: "audio", FileID: msg.Audio.FileID, FileName: msg.Audio.FileName, MimeType: msg.Audio.MimeType, Duration: msg.Audio.Duration, Performer: msg.Audio.Performer, Title: msg.Audio.Title}
+	case msg.Voice != nil:
+		return &chatLogMedia{Type: "voice", FileID: msg.Voice.FileID, MimeType: msg.Voice.MimeType, Duration: msg.Voice.Duration}
+	case msg.VideoNote != nil:
+		return &chatLogMedia{Type: "video_note", FileID: msg.VideoNote.FileID, Duration: msg.VideoNote.Duration}
+	case msg.Document != nil:
+		return &chatLogMedia{Type: "document", FileID: msg.Document.FileID, FileName: msg.Document.FileName, MimeType: msg.Document.MimeType}
+	case msg.Sticker != nil:
+		return &chatLogMedia{Type: "sticker", FileID: msg.Sticker.FileID, Emoji: msg.Sticker.Emoji}
+	}
+	return nil
+}
+
+func newChatLogRecord(msg *models.Message) chatLogRecord {
+	record := chatLogRecord{
+		Time:         formatLogTime(msg.Date),
+		ChatID:       msg.Chat.ID,
+		ChatType:     string(msg.Chat.Type),
+		ChatTitle:    getChatLogName(msg.Chat),
+		MessageID:    msg.ID,
+		ThreadID:     msg.MessageThreadID,
+		Text:         msg.Text,
+		Caption:      msg.Caption,
+		MediaGroupID: msg.MediaGroupID,
+		Media:        getMessageMedia(msg),
+	}
+
+	if msg.EditDate != 0 {
+		record.EditTime = formatLogTime(msg.EditDate)
+	}
+	record.Kind, _, _ = describeMessage(msg)
+	if record.Kind != "text" {
+		record.Summary = formatMessageLogText(msg)
+	}
+	if msg.From != nil {
+		record.UserID = msg.From.ID
+		record.Username = msg.From.Username
+	}
+	if msg.SenderChat != nil {
+		record.SenderChatID = msg.SenderChat.ID
+	}
+	if _, name, ok := getMessageAuthor(msg); ok {
+		record.Name = name
+	}
+	if msg.ReplyToMessage != nil {
+		record.ReplyToMessageID = msg.ReplyToMessage.ID
+	}
+	if msg.ForwardOrigin != nil {
+		record.ForwardFromKey, record.ForwardFrom, _ = getForwardTarget(msg.ForwardOrigin)
+		if date := getForwardDate(msg.ForwardOrigin); date != 0 {
+			record.ForwardDate = formatLogTime(date)
+		}
+	}
+	if msg.ViaBot != nil {
+		record.ViaBot = msg.ViaBot.Username
+	}
+	return record
+}
+
+func formatChatLogRecord(record chatLogRecord) (string, error) {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return "", err
+	}
+	return string(data) + "\n", nil
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+// logAliasesFile lists "chat_id<TAB>name<TAB>first seen day" lines, so a
+// human can find the directory of a chat by its title or username.
+const logAliasesFile = "aliases.tsv"
+
+// logsMu guards the alias index, chat log writers of different chats may
+// register their names at the same time.
+var logsMu sync.Mutex
+
+var knownLogAliases map[string]bool
+
+func logAliasKey(chatID int64, name string) string {
+	return strconv.FormatInt(chatID, 10) + "\t" + name
+}
+
+// loadLogAliases reads the alias index and returns every chat ID that ever
+// had the name, in the order they were seen.
+func loadLogAliases(root string) (map[string][]int64, error) {
+	aliases := make(map[string][]int64)
+	f, err := os.Open(filepath.Join(root, logAliasesFile))
+	if os.IsNotExist(err) {
+		return aliases, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		parts := strings.Split(scanner.Text(), "\t")
+		if len(parts) < 2 {
+			continue
+		}
+		chatID, err := strconv.ParseInt(parts[0], 10, 64)
+		if err != nil {
+			continue
+		}
+		if !slices.Contains(aliases[parts[1]], chatID) {
+			aliases[parts[1]] = append(aliases[parts[1]], chatID)
+		}
+	}
+	return aliases, scanner.Err()
+}
+
+// latestLogAlias returns the name the chat had the last time it was seen.
+func latestLogAlias(root string, chatID int64) (string, error) {
+	f, err := os.Open(filepath.Join(root, logAliasesFile))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var name string
+	prefix := strconv.FormatInt(chatID, 10) + "\t"
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if rest, found := strings.CutPrefix(scanner.Text(), prefix); found {
+			name, _, _ = strings.Cut(rest, "\t")
+		}
+	}
+	return name, scanner.Err()
+}
+
+func appendLogAlias(root string, chatID int64, name string) error {
+	if err := os.MkdirAll(root, 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(root, logAliasesFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = fmt.Fprintf(f, "%s\t%s\n", logAliasKey(chatID, name), time.Now().Format(dayLayout))
+	return err
+}
+
+// registerLogAlias remembers the current chat name in the alias index. Old
+// title-named directories are left to migrate-logs, a new chat may take an
+// old title or a user may have the name of a group. Must be called with
+// logsMu held.
+func registerLogAlias(chat models.Chat) {
+	name := strings.TrimSpace(getChatLogName(chat))
+	if name == "" || strings.ContainsAny(name, "\t\n") {
+		return
+	}
+
+	root, err := getLogsRoot()
+	if err != nil {
+		log.Println("Can't get logs directory")
+		log.Println(err)
+		return
+	}
+
+	if knownLogAliases == nil {
+		aliases, err := loadLogAliases(root)
+		if err != nil {
+			log.Println("Can't load log aliases")
+			log.Println(err)
+			return
+		}
+		knownLogAliases = make(map[string]bool)
+		for aliasName, chatIDs := range aliases {
+			for _, chatID := range chatIDs {
+				knownLogAliases[logAliasKey(chatID, aliasName)] = true
+			}
+		}
+	}
+
+	key := logAliasKey(chat.ID, name)
+	if knownLogAliases[key] {
+		return
+	}
+
+	if err = appendLogAlias(root, chat.ID, name); err != nil {
+		log.Println("Can't save log alias")
+		log.Println(err)
+		return
+	}
+	knownLogAliases[key] = true
+}
+
+// isLegacyLogDir reports whether the name is an old title-named directory,
+// chat ID directories and the index file are skipped.
+func isLegacyLogDir(root string, name string) bool {
+	if name == "" || name == "." || name == ".." || name == logAliasesFile || strings.ContainsAny(name, `/\`) {
+		return false
+	}
+	if _, err := strconv.ParseInt(name, 10, 64); err == nil {
+		return false
+	}
+	info, err := os.Stat(filepath.Join(root, name))
+	return err == nil && info.IsDir()
+}
+
+// mergeLogDir moves the logs from the title-named directory into the chat ID
+// directory, days present in both are merged keeping the time order. Today
+// is left alone, a running bot keeps today's files open and would go on
+// writing into the replaced ones.
+func mergeLogDir(root string, name string, chatID int64) error {
+	if !isLegacyLogDir(root, name) {
+		return nil
+	}
+	src := filepath.Join(root, name)
+	dst := filepath.Join(root, strconv.FormatInt(chatID, 10))
+	now := time.Now()
+	today := filepath.FromSlash(fmt.Sprintf("%d/%d/%d", now.Year(), now.Month(), now.Day()))
+
+	keptToday := false
+	err := filepath.WalkDir(src, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		if filepath.Dir(rel) == today {
+			keptToday = true
+			return nil
+		}
+		target := filepath.Join(dst, rel)
+		if err = os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return err
+		}
+		if !fileExists(target) && !fileExists(target+".gz") {
+			return os.Rename(path, target)
+		}
+		return mergeLogFiles(path, target)
+	})
+	if err != nil {
+		return err
+	}
+
+	if keptToday {
+		log.Printf("Merged logs of %s into chat %d except today, run migrate-logs again tomorrow", name, chatID)
+		return nil
+	}
+	log.Printf("Merged logs of %s into chat %d", name, chatID)
+	return os.RemoveAll(src)
+}
+
+type logLine struct {
+	sortKey string
+	text    string
+}
+
+// readLogLines splits a log file into messages with a key to sort them by:
+// the clock of a text log line or the time of a JSONL record.
+func readLogLines(path string) ([]logLine, error) {
+	data, err := readLogFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var lines []logLine
+	jsonl := strings.HasSuffix(path, ".jsonl")
+	for _, line := range strings.SplitAfter(string(data), "\n") {
+		if line == "" {
+			continue
+		}
+		if jsonl {
+			var record struct {
+				Time string `json:"time"`
+			}
+			_ = json.Unmarshal([]byte(line), &record)
+			lines = append(lines, logLine{sortKey: record.Time, text: line})
+			continue
+		}
+		if len(line) > len(logTimeLayout)+2 && line[0] == '[' && line[len(logTimeLayout)+1] == ']' || len(lines) == 0 {
+			lines = append(lines, logLine{sortKey: line[:min(len(line), len(logTimeLayout)+2)], text: line})
+			continue
+		}
+		lines[len(lines)-1].text += line
+	}
+	return lines, nil
+}
+
+func mergeLogFiles(src string, dst string) error {
+	srcLines, err := readLogLines(src)
+	if err != nil {
+		return err
+	}
+	dstLines, err := readLogLines(dst)
+	if err != nil {
+		return err
+	}
+
+	lines := append(srcLines, dstLines...)
+	sort.SliceStable(lines, func(i, j int) bool {
+		return lines[i].sortKey < lines[j].sortKey
+	})
+
+	var buf bytes.Buffer
+	for _, line := range lines {
+		buf.WriteString(line.text)
+		if !strings.HasSuffix(line.text, "\n") {
+			buf.WriteString("\n")
+		}
+	}
+
+	// Old days are kept compressed, the merged day stays compressed too.
+	if !fileExists(dst) {
+		var gzBuf bytes.Buffer
+		zw := gzip.NewWriter(&gzBuf)
+		if _, err = zw.Write(buf.Bytes()); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+		buf = gzBuf
+		dst += ".gz"
+	}
+
+	tmp := dst + ".merge"
+	if err = os.WriteFile(tmp, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	if err = os.Rename(tmp, dst); err != nil {
+		return err
+	}
+	return os.Remove(src)
+}
+
+// listLogDays returns the days that have a log directory, the latest first.
+func listLogDays(chatID int64) ([]time.Time, error) {
+	root, err := getLogsRoot()
+	if err != nil {
+		return nil, err
+	}
+	chatDir := filepath.Join(root, strconv.FormatInt(chatID, 10))
+
+	var days []time.Time
+	years, err := readNumberDirs(chatDir)
+	if err != nil {
+		return nil, err
+	}
+	for _, year := range years {
+		months, err := readNumberDirs(filepath.Join(chatDir, strconv.Itoa(year)))
+		if err != nil {
+			return nil, err
+		}
+		for _, month := range months {
+			dayNumbers, err := readNumberDirs(filepath.Join(chatDir, strconv.Itoa(year), strconv.Itoa(month)))
+			if err != nil {
+				return nil, err
+			}
+			for _, day := range dayNumbers {
+				days = append(days, time.Date(year, time.Month(month), day, 0, 0, 0, 0, time.Local))
+			}
+		}
+	}
+
+	sort.Slice(days, func(i, j int) bool {
+		return days[i].After(days[j])
+	})
+	return days, nil
+}
+
+func readNumberDirs(dir string) ([]int, error) {
+	entries, err := os.ReadDir(dir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var numbers []int
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		if number, err := strconv.Atoi(entry.Name()); err == nil {
+			numbers = append(numbers, number)
+		}
+	}
+	return numbers, nil
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func runMigrateLogs(args []string) int {
+	flags := flag.NewFlagSet("migrate-logs", flag.ExitOnError)
+	mapping := make(map[string]int64)
+	flags.Func("map", "merge a directory into a chat, as Title=chat_id, can be repeated", func(value string) error {
+		name, id, found := strings.Cut(value, "=")
+		if !found {
+			return fmt.Errorf("expected Title=chat_id")
+		}
+		chatID, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			return err
+		}
+		mapping[name] = chatID
+		return nil
+	})
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goBotter migrate-logs [flags]")
+		fmt.Fprintln(os.Stderr, "Merges title-named log directories into chat ID directories. Stop the bot")
+		fmt.Fprintln(os.Stderr, "first, merging rewrites log files it may hold open. Today is never merged.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	root, err := getLogsRoot()
+	if err != nil {
+		log.Println("Can't get logs directory")
+		log.Println(err)
+		return 1
+	}
+
+	aliases, err := loadLogAliases(root)
+	if err != nil {
+		log.Println("Can't load log aliases")
+		log.Println(err)
+		return 1
+	}
+
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		log.Println("Can't read logs directory")
+		log.Println(err)
+		return 1
+	}
+
+	code := 0
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isLegacyLogDir(root, name) {
+			continue
+		}
+		chatID, mapped := mapping[name]
+		if !mapped {
+			// Only a name that never moved between chats is safe to merge.
+			chatIDs := aliases[name]
+			if len(chatIDs) != 1 {
+				if len(chatIDs) == 0 {
+					log.Printf("Unknown chat for %s, pass -map '%s=<chat_id>'", name, name)
+				} else {
+					log.Printf("%s was the name of several chats %v, pass -map '%s=<chat_id>'", name, chatIDs, name)
+				}
+				code = 1
+				continue
+			}
+			chatID = chatIDs[0]
+		}
+		if err = mergeLogDir(root, name, chatID); err != nil {
+			log.Printf("Can't merge logs of %s", name)
+			log.Println(err)
+			return 1
+		}
+		if mapped {
+			if err = appendLogAlias(root, chatID, name); err != nil {
+				log.Println("Can't save log alias")
+				log.Println(err)
+				return 1
+			}
+		}
+	}
+	return code
+}
@@ -7,6 +7,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
@@ -46,53 +47,90 @@ func ensureBotStatusLogged(ctx context.Context, b *bot.Bot, chatID int64) {
 	checkedChatStatus.Store(chatID, true)
 }
 
-func getLogsFilePath(channel string) string {
-	currentTime := time.Now()
+func isChatAdmin(ctx context.Context, b *bot.Bot, chat models.Chat, userID int64) bool {
+	if chat.Type == "private" {
+		return true
+	}
+
+	member, err := b.GetChatMember(ctx, &bot.GetChatMemberParams{
+		ChatID: chat.ID,
+		UserID: userID,
+	})
+	if err != nil {
+		log.Println("Can't get chat member status")
+		log.Println(err)
+		return false
+	}
+	return member.Type == models.ChatMemberTypeAdministrator || member.Type == models.ChatMemberTypeOwner
+}
+
+func getLogsRoot() (string, error) {
 	ex, err := os.Executable()
 	if err != nil {
-		log.Fatal(err)
+		return "", err
 	}
-	curDir := filepath.Dir(ex)
-	logsDir := filepath.Join(curDir, "logs", channel, fmt.Sprintf("%d/%d/%d", currentTime.Year(), currentTime.Month(), currentTime.Day()))
-	if _, err := os.Stat(logsDir); os.IsNotExist(err) {
-		err = os.MkdirAll(logsDir, 0755)
-		if err != nil {
-			log.Fatal(err)
-		}
+	return filepath.Join(filepath.Dir(ex), "logs"), nil
+}
+
+// getLogsDayDir returns the directory with the chat logs for the given day,
+// the directory may not exist yet. Directories are named by chat ID so a
+// renamed chat keeps its history in one place.
+func getLogsDayDir(chatID int64, day time.Time) (string, error) {
+	root, err := getLogsRoot()
+	if err != nil {
+		return "", err
 	}
-	logsFile := filepath.Join(logsDir, "logs.log")
+	return filepath.Join(root, strconv.FormatInt(chatID, 10), fmt.Sprintf("%d/%d/%d", day.Year(), day.Month(), day.Day())), nil
+}
 
-	if _, err := os.Stat(logsFile); os.IsNotExist(err) {
-		f, err := os.Create(logsFile)
-		if err != nil {
-			log.Fatal(err)
-		}
-		defer f.Close()
+func getChatLogName(chat models.Chat) string {
+	if chat.Type == "private" {
+		return chat.Username
 	}
-	return logsFile
+	return chat.Title
 }
 
 func handleLogMessage(ctx context.Context, b *bot.Bot, update *models.Update) {
-	var chatName string
-	log.Println("Check chat type")
-	log.Println(update.Message.Chat)
-	if update.Message.Chat.Type == "private" {
-		chatName = update.Message.Chat.Username
-	} else {
-		chatName = update.Message.Chat.Title
+	msg := update.Message
+	if msg == nil {
+		msg = update.EditedMessage
 	}
 
-	logPath := getLogsFilePath(chatName)
+	log.Println("Check chat type")
+	log.Println(msg.Chat)
 
-	f, err := os.OpenFile(logPath, os.O_APPEND|os.O_WRONLY, 0644)
+	settings, err := getChatLogSettings(msg.Chat.ID)
 	if err != nil {
-		log.Fatal(err)
+		// Without the settings we don't know what may be written.
+		log.Println("Can't get chat log settings, message is not logged")
+		log.Println(err)
+		return
 	}
-	defer f.Close()
+	if settings.disabled {
+		return
+	}
+
 	currentTime := time.Now()
-	_, err = f.WriteString(fmt.Sprintf("[%s] [%s] %s\n", currentTime.Format("15:04:05"), update.Message.From.Username, update.Message.Text))
-	if err != nil {
-		log.Fatal(err)
+	if writesTextLogs() {
+		text := settings.redactText(formatMessageLogText(msg))
+		if update.EditedMessage != nil {
+			// Edits point to the original message by its time, the ID is the same.
+			original := time.Unix(int64(msg.Date), 0).In(time.Local)
+			text = fmt.Sprintf("[edit of %s] %s", original.Format("15:04:05"), text)
+		}
+		writeChatLog(msg.Chat, "logs.log", fmt.Sprintf("[%s] [#%d] [%s] %s\n", currentTime.Format("15:04:05"), msg.ID, getLogAuthor(msg), text), currentTime)
+	}
+
+	if writesJSONLogs() {
+		record := newChatLogRecord(msg)
+		settings.redactRecord(&record)
+		line, err := formatChatLogRecord(record)
+		if err != nil {
+			log.Println("Can't marshal chat log record")
+			log.Println(err)
+			return
+		}
+		writeChatLog(msg.Chat, "logs.jsonl", line, currentTime)
 	}
 }
 
@@ -130,6 +168,13 @@ func handleAllMessages(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 
+	if update.EditedMessage != nil {
+		log.Println("Log edited message")
+		handleLogMessage(ctx, b, update)
+		handleEditToStats(ctx, update.EditedMessage)
+		return
+	}
+
 	if update.Message == nil {
 		return
 	}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	redactPhone = "phone"
+	redactEmail = "email"
+	redactCard  = "card"
+
+	logRetentionCheckInterval = time.Hour
+)
+
+var (
+	emailPattern = regexp.MustCompile(`(?i)[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,}`)
+	cardPattern  = regexp.MustCompile(`\b\d(?:[ \-]?\d){12,18}\b`)
+	phonePattern = regexp.MustCompile(`(?:\+|\(|\b)\d(?:[ \-()]{0,2}\d){9,18}\b`)
+)
+
+var redactLabels = map[string]string{
+	redactPhone: "телефоны",
+	redactEmail: "почта",
+	redactCard:  "карты",
+}
+
+// chatLogSettings is the log policy of one chat, the zero value keeps
+// everything forever.
+type chatLogSettings struct {
+	disabled      bool
+	retentionDays int
+	redact        []string
+}
+
+// chatLogSettingsCache keeps the settings by chat ID, they are read for every
+// logged message.
+var chatLogSettingsCache sync.Map
+
+func getChatLogSettings(chatID int64) (chatLogSettings, error) {
+	if cached, exists := chatLogSettingsCache.Load(chatID); exists {
+		return cached.(chatLogSettings), nil
+	}
+
+	var settings chatLogSettings
+	if statsDB == nil {
+		return settings, nil
+	}
+
+	var disabled int
+	var redact string
+	err := statsDB.QueryRow("SELECT disabled, retention_days, redact FROM chat_log_settings WHERE chat_id = ?", chatID).Scan(&disabled, &settings.retentionDays, &redact)
+	if err != nil && err != sql.ErrNoRows {
+		return settings, err
+	}
+	settings.disabled = disabled != 0
+	settings.redact = strings.Fields(redact)
+
+	chatLogSettingsCache.Store(chatID, settings)
+	return settings, nil
+}
+
+func saveChatLogSettings(chatID int64, settings chatLogSettings) error {
+	disabled := 0
+	if settings.disabled {
+		disabled = 1
+	}
+	_, err := statsDB.Exec(`
+		INSERT INTO chat_log_settings(chat_id, disabled, retention_days, redact, updated_at)
+		VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			disabled = excluded.disabled,
+			retention_days = excluded.retention_days,
+			redact = excluded.redact,
+			updated_at = excluded.updated_at
+	`, chatID, disabled, settings.retentionDays, strings.Join(settings.redact, " "), time.Now().Unix())
+	if err != nil {
+		return err
+	}
+	chatLogSettingsCache.Store(chatID, settings)
+	return nil
+}
+
+// isValidCardNumber checks the Luhn sum, so long numbers that aren't cards
+// are kept as is.
+func isValidCardNumber(number string) bool {
+	sum := 0
+	double := false
+	digits := 0
+	for i := len(number) - 1; i >= 0; i-- {
+		if number[i] < '0' || number[i] > '9' {
+			continue
+		}
+		digit := int(number[i] - '0')
+		if double {
+			digit *= 2
+			if digit > 9 {
+				digit -= 9
+			}
+		}
+		sum += digit
+		double = !double
+		digits++
+	}
+	return digits >= 13 && sum%10 == 0
+}
+
+func countDigits(text string) int {
+	digits := 0
+	for _, r := range text {
+		if r >= '0' && r <= '9' {
+			digits++
+		}
+	}
+	return digits
+}
+
+// looksLikePhone tells a phone number from other long numbers like IDs,
+// order numbers or timestamps: it has 10 to 15 digits and is written as a
+// phone, with a leading +, a (code) or a 7/8 prefix followed by separators.
+func looksLikePhone(number string) bool {
+	if digits := countDigits(number); digits < 10 || digits > 15 {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(number, "+"), strings.Contains(number, "("):
+		return true
+	case strings.HasPrefix(number, "7"), strings.HasPrefix(number, "8"):
+		return strings.ContainsAny(number, " -")
+	}
+	return false
+}
+
+// redactText replaces the enabled kinds of personal data with placeholders.
+// Cards go before phones, a card number also looks like a long phone.
+func (s chatLogSettings) redactText(text string) string {
+	if text == "" || len(s.redact) == 0 {
+		return text
+	}
+	enabled := make(map[string]bool, len(s.redact))
+	for _, kind := range s.redact {
+		enabled[kind] = true
+	}
+
+	if enabled[redactEmail] {
+		text = emailPattern.ReplaceAllString(text, "[email]")
+	}
+	if enabled[redactCard] {
+		text = cardPattern.ReplaceAllStringFunc(text, func(match string) string {
+			if isValidCardNumber(match) {
+				return "[карта]"
+			}
+			return match
+		})
+	}
+	if enabled[redactPhone] {
+		text = phonePattern.ReplaceAllStringFunc(text, func(match string) string {
+			if looksLikePhone(match) {
+				return "[телефон]"
+			}
+			return match
+		})
+	}
+	return text
+}
+
+func (s chatLogSettings) redactRecord(record *chatLogRecord) {
+	record.Text = s.redactText(record.Text)
+	record.Caption = s.redactText(record.Caption)
+	record.Summary = s.redactText(record.Summary)
+}
+
+// storedMessageText applies the log policy of the chat to a message text kept
+// in the database, nothing is kept for chats with logging turned off.
+func storedMessageText(chatID int64, text string) string {
+	settings, err := getChatLogSettings(chatID)
+	if err != nil {
+		log.Println("Can't get chat log settings")
+		log.Println(err)
+		return ""
+	}
+	if settings.disabled {
+		return ""
+	}
+	return settings.redactText(text)
+}
+
+// logRetentionCutoff is the start of the oldest day kept.
+func logRetentionCutoff(retentionDays int) time.Time {
+	now := time.Now()
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local).AddDate(0, 0, -retentionDays)
+}
+
+// removeExpiredMessageTexts deletes the message texts and edit history kept
+// for !seen and !правки with the same period as the log files.
+func removeExpiredMessageTexts(chatID int64, retentionDays int) error {
+	if retentionDays <= 0 {
+		return nil
+	}
+	cutoff := logRetentionCutoff(retentionDays).Unix()
+	if _, err := statsDB.Exec("DELETE FROM message_text_state WHERE chat_id = ? AND message_date < ?", chatID, cutoff); err != nil {
+		return err
+	}
+	_, err := statsDB.Exec("DELETE FROM message_edits WHERE chat_id = ? AND edited_at < ?", chatID, cutoff)
+	return err
+}
+
+// removeExpiredLogs deletes the day directories older than the retention
+// period, today is never removed.
+func removeExpiredLogs(chatID int64, retentionDays int) error {
+	if retentionDays <= 0 {
+		return nil
+	}
+	days, err := listLogDays(chatID)
+	if err != nil {
+		return err
+	}
+
+	cutoff := logRetentionCutoff(retentionDays)
+	for _, day := range days {
+		if !day.Before(cutoff) {
+			continue
+		}
+		dayDir, err := getLogsDayDir(chatID, day)
+		if err != nil {
+			return err
+		}
+		if err = os.RemoveAll(dayDir); err != nil {
+			return err
+		}
+		// Empty month and year directories go too, Remove fails on the rest.
+		monthDir := filepath.Dir(dayDir)
+		_ = os.Remove(monthDir)
+		_ = os.Remove(filepath.Dir(monthDir))
+		log.Printf("Removed logs of chat %d for %s", chatID, day.Format(dayLayout))
+	}
+	return nil
+}
+
+func checkLogRetention() {
+	rows, err := statsDB.Query("SELECT chat_id, retention_days FROM chat_log_settings WHERE retention_days > 0")
+	if err != nil {
+		log.Println("Can't get log retention settings")
+		log.Println(err)
+		return
+	}
+	type retention struct {
+		chatID int64
+		days   int
+	}
+	var items []retention
+	for rows.Next() {
+		var item retention
+		if err = rows.Scan(&item.chatID, &item.days); err != nil {
+			rows.Close()
+			log.Println("Can't read log retention settings")
+			log.Println(err)
+			return
+		}
+		items = append(items, item)
+	}
+	rows.Close()
+
+	for _, item := range items {
+		if err = removeExpiredLogs(item.chatID, item.days); err != nil {
+			log.Printf("Can't remove old logs of chat %d", item.chatID)
+			log.Println(err)
+		}
+		if err = removeExpiredMessageTexts(item.chatID, item.days); err != nil {
+			log.Printf("Can't remove old message texts of chat %d", item.chatID)
+			log.Println(err)
+		}
+	}
+}
+
+// runLogRetention removes expired logs and message texts every hour, chats
+// with logging turned off still lose their old files on time.
+func runLogRetention(ctx context.Context) {
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	ticker := time.NewTicker(logRetentionCheckInterval)
+	defer ticker.Stop()
+
+	checkLogRetention()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkLogRetention()
+		}
+	}
+}
+
+func formatChatLogSettings(settings chatLogSettings) string {
+	msg := "Логи чата пишутся\n"
+	if settings.disabled {
+		msg = "Логи чата не пишутся\n"
+	}
+	if settings.retentionDays > 0 {
+		msg += fmt.Sprintf("Хранятся %d дн.\n", settings.retentionDays)
+	} else {
+		msg += "Хранятся бессрочно\n"
+	}
+	if len(settings.redact) > 0 {
+		var labels []string
+		for _, kind := range settings.redact {
+			labels = append(labels, redactLabels[kind])
+		}
+		msg += "Скрываются: " + strings.Join(labels, ", ") + "\n"
+	} else {
+		msg += "Ничего не скрывается\n"
+	}
+	return msg
+}
+
+func handleLogSettings(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle chat log settings")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	parts := strings.Fields(update.Message.Text)
+
+	settings, err := getChatLogSettings(chatID)
+	if err != nil {
+		log.Println("Can't get chat log settings")
+		log.Println(err)
+		return
+	}
+
+	if len(parts) < 2 {
+		sendText(ctx, b, update, formatChatLogSettings(settings)+"Настройки: !логи on, !логи off, !логи keep <дней>, !логи redact phone email card или none")
+		return
+	}
+
+	if update.Message.From == nil || !isChatAdmin(ctx, b, update.Message.Chat, update.Message.From.ID) {
+		sendText(ctx, b, update, "Настраивать логи могут только администраторы чата")
+		return
+	}
+
+	switch parts[1] {
+	case "on":
+		settings.disabled = false
+	case "off":
+		settings.disabled = true
+	case "keep":
+		if len(parts) != 3 {
+			sendText(ctx, b, update, "Нужно указать срок в днях: !логи keep 30, бессрочно: !логи keep 0")
+			return
+		}
+		days, err := strconv.Atoi(parts[2])
+		if err != nil || days < 0 {
+			sendText(ctx, b, update, "Срок хранения должен быть числом дней, 0 — бессрочно")
+			return
+		}
+		settings.retentionDays = days
+	case "redact":
+		if len(parts) < 3 {
+			sendText(ctx, b, update, "Нужно указать что скрывать: phone, email, card или none")
+			return
+		}
+		var redact []string
+		for _, kind := range parts[2:] {
+			if kind == "none" {
+				redact = nil
+				continue
+			}
+			if _, known := redactLabels[kind]; !known {
+				sendText(ctx, b, update, "Не знаю что такое "+kind+", можно: phone, email, card или none")
+				return
+			}
+			redact = append(redact, kind)
+		}
+		settings.redact = redact
+	default:
+		sendText(ctx, b, update, "Странная команда, можно: !логи on, !логи off, !логи keep <дней> или !логи redact ...")
+		return
+	}
+
+	if err = saveChatLogSettings(chatID, settings); err != nil {
+		log.Println("Can't save chat log settings")
+		log.Println(err)
+		return
+	}
+	if err = removeExpiredLogs(chatID, settings.retentionDays); err != nil {
+		log.Printf("Can't remove old logs of chat %d", chatID)
+		log.Println(err)
+	}
+	if err = removeExpiredMessageTexts(chatID, settings.retentionDays); err != nil {
+		log.Printf("Can't remove old message texts of chat %d", chatID)
+		log.Println(err)
+	}
+	sendText(ctx, b, update, formatChatLogSettings(settings))
+}
@@ -0,0 +1,47 @@
+package main
+
+import "testing"
+
+func TestRedactText(t *testing.T) {
+	settings := chatLogSettings{redact: []string{redactPhone, redactEmail, redactCard}}
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"звони +7 999 123-45-67", "звони [телефон]"},
+		{"звони +79991234567", "звони [телефон]"},
+		{"звони 8 (999) 123-45-67", "звони [телефон]"},
+		{"звони (999) 123-45-67", "звони [телефон]"},
+		{"звони 8-800-555-35-35", "звони [телефон]"},
+		{"пиши a.b@example.com", "пиши [email]"},
+		{"карта 4111 1111 1111 1111", "карта [карта]"},
+		{"карта 4111111111111111", "карта [карта]"},
+
+		// Long numbers that aren't phones stay as they are.
+		{"id 1234567890123", "id 1234567890123"},
+		{"юзер 89991234567", "юзер 89991234567"},
+		{"заказ 123-456-789-012", "заказ 123-456-789-012"},
+		{"время 1700000000", "время 1700000000"},
+		{"2024-01-01 12:00:00", "2024-01-01 12:00:00"},
+		{"2024-01-01 1234", "2024-01-01 1234"},
+		{"+1234567890123456", "+1234567890123456"},
+		{"не карта 4111 1111 1111 1112", "не карта 4111 1111 1111 1112"},
+		{"+7 999", "+7 999"},
+	}
+	for _, test := range tests {
+		if got := settings.redactText(test.text); got != test.want {
+			t.Errorf("redactText(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestRedactTextDisabledKinds(t *testing.T) {
+	settings := chatLogSettings{redact: []string{redactEmail}}
+	text := "+7 999 123-45-67 a@example.com"
+	if got, want := settings.redactText(text), "+7 999 123-45-67 [email]"; got != want {
+		t.Errorf("redactText(%q) = %q, want %q", text, got, want)
+	}
+	if got := (chatLogSettings{}).redactText(text); got != text {
+		t.Errorf("redactText without kinds = %q, want %q", got, text)
+	}
+}
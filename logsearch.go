@@ -0,0 +1,458 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	logSearchPrefix   = "ls:"
+	logSearchPageSize = 10
+	logSearchLimit    = 200
+	logSearchMaxText  = 200
+	logSearchTTL      = 7 * 24 * time.Hour
+)
+
+type logSearchQuery struct {
+	terms   []string
+	user    string
+	since   time.Time
+	until   time.Time
+	invalid string
+}
+
+// logMessage is one message read back from the chat logs. The text log keeps
+// the message ID and the edit time, the user and reply IDs are known only for
+// JSONL logs.
+type logMessage struct {
+	time      time.Time
+	editTime  time.Time
+	author    string
+	username  string
+	text      string
+	userID    int64
+	messageID int
+	replyToID int
+}
+
+// parseLogSearchQuery splits the text into words, an @user filter and a day
+// or a day range written as 2024-01-01..2024-01-31.
+func parseLogSearchQuery(text string) logSearchQuery {
+	var query logSearchQuery
+	for _, token := range strings.Fields(normalizeSearchText(text)) {
+		if user, ok := strings.CutPrefix(token, "@"); ok && user != "" {
+			query.user = user
+			continue
+		}
+		if from, to, isRange := strings.Cut(token, ".."); isRange || looksLikeSearchDay(token) {
+			if !isRange {
+				to = from
+			}
+			since, validSince := parseSearchDay(from)
+			until, validUntil := parseSearchDay(to)
+			if !validSince || !validUntil || until.Before(since) {
+				query.invalid = token
+				continue
+			}
+			query.since = since
+			query.until = until.AddDate(0, 0, 1)
+			continue
+		}
+		query.terms = append(query.terms, token)
+	}
+	return query
+}
+
+// looksLikeSearchDay catches mistyped days like 2024-02-30, so they are
+// reported instead of being searched as text.
+func looksLikeSearchDay(token string) bool {
+	return len(token) == len(dayLayout) && token[4] == '-' && token[7] == '-'
+}
+
+func (q logSearchQuery) matches(hit logMessage) bool {
+	if !q.since.IsZero() && hit.time.Before(q.since) {
+		return false
+	}
+	if !q.until.IsZero() && !hit.time.Before(q.until) {
+		return false
+	}
+	if q.user != "" && !strings.EqualFold(hit.username, q.user) && normalizeSearchText(hit.author) != q.user {
+		return false
+	}
+	text := normalizeSearchText(hit.text)
+	for _, term := range q.terms {
+		if !strings.Contains(text, term) {
+			return false
+		}
+	}
+	return true
+}
+
+// readLogMessages reads the messages of one day, the JSONL log is preferred
+// because it keeps message IDs for links.
+func readLogMessages(chatID int64, day time.Time) ([]logMessage, error) {
+	logsDir, err := getLogsDayDir(chatID, day)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := readLogFile(filepath.Join(logsDir, "logs.jsonl"))
+	if err == nil {
+		var messages []logMessage
+		for _, line := range strings.Split(string(data), "\n") {
+			if line == "" {
+				continue
+			}
+			var record chatLogRecord
+			if err = json.Unmarshal([]byte(line), &record); err != nil {
+				continue
+			}
+			at, err := time.Parse(time.RFC3339, record.Time)
+			if err != nil {
+				continue
+			}
+			text := record.Text
+			if record.Summary != "" {
+				text = record.Summary
+			}
+			author := record.Name
+			if record.Username != "" {
+				author = record.Username
+			}
+			message := logMessage{
+				time:      at,
+				author:    author,
+				username:  record.Username,
+				text:      text,
+				userID:    record.UserID,
+				messageID: record.MessageID,
+				replyToID: record.ReplyToMessageID,
+			}
+			if message.userID == 0 {
+				message.userID = record.SenderChatID
+			}
+			if record.EditTime != "" {
+				message.editTime, _ = time.Parse(time.RFC3339, record.EditTime)
+			}
+			messages = append(messages, message)
+		}
+		return messages, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	entries, err := readLogEntries(filepath.Join(logsDir, "logs.log"), day)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	messages := make([]logMessage, 0, len(entries))
+	for _, entry := range entries {
+		messages = append(messages, parseTextLogEntry(entry))
+	}
+	return messages, nil
+}
+
+// parseTextLogEntry splits a text log line. Lines look like
+// "[15:04:05] [#123] [author] text", an edit of the message also has
+// "[edit of 15:04:05]" before the text. Older lines have no "[#123]" and
+// keep the ID in the edit mark as "[edit of 15:04:05 #123]".
+func parseTextLogEntry(entry logEntry) logMessage {
+	message := logMessage{time: entry.time}
+	rest := entry.text[len(logTimeLayout)+2:]
+	if tail, found := strings.CutPrefix(rest, " [#"); found {
+		if id, text, found := strings.Cut(tail, "]"); found {
+			if messageID, err := strconv.Atoi(id); err == nil {
+				message.messageID = messageID
+				rest = text
+			}
+		}
+	}
+	if strings.HasPrefix(rest, " [") {
+		if name, text, found := strings.Cut(rest[2:], "] "); found {
+			message.author = name
+			message.username = name
+			rest = text
+		}
+	}
+
+	if mark, text, found := strings.Cut(rest, "]"); found && strings.HasPrefix(mark, "[edit of ") {
+		fields := append(strings.Fields(strings.TrimPrefix(mark, "[edit of ")), "")
+		original, err := time.ParseInLocation(logTimeLayout, fields[0], time.Local)
+		if err == nil {
+			message.editTime = entry.time
+			message.time = time.Date(entry.time.Year(), entry.time.Month(), entry.time.Day(), original.Hour(), original.Minute(), original.Second(), 0, time.Local)
+			// The message may be from the day before.
+			if message.time.After(entry.time) {
+				message.time = message.time.AddDate(0, 0, -1)
+			}
+			if id, err := strconv.Atoi(strings.TrimPrefix(fields[1], "#")); err == nil {
+				message.messageID = id
+			}
+			rest = text
+		}
+	}
+	message.text = strings.TrimSpace(rest)
+	return message
+}
+
+// searchChatLogs returns up to logSearchLimit matching messages, the latest
+// first, and whether there were more.
+func searchChatLogs(chatID int64, query logSearchQuery) ([]logMessage, bool, error) {
+	days, err := listLogDays(chatID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var found []logMessage
+	for _, day := range days {
+		if !query.until.IsZero() && !day.Before(query.until) {
+			continue
+		}
+		if !query.since.IsZero() && day.Before(query.since) {
+			break
+		}
+
+		hits, err := readLogMessages(chatID, day)
+		if err != nil {
+			return nil, false, err
+		}
+		for i := len(hits) - 1; i >= 0; i-- {
+			if !query.matches(hits[i]) {
+				continue
+			}
+			if len(found) == logSearchLimit {
+				return found, true, nil
+			}
+			found = append(found, hits[i])
+		}
+	}
+	return found, false, nil
+}
+
+// getMessageLink returns a t.me link to the message, basic groups and
+// private chats have none.
+func getMessageLink(chat models.Chat, messageID int) string {
+	if messageID == 0 {
+		return ""
+	}
+	if chat.Username != "" {
+		return fmt.Sprintf("https://t.me/%s/%d", chat.Username, messageID)
+	}
+	if id, ok := strings.CutPrefix(strconv.FormatInt(chat.ID, 10), "-100"); ok {
+		return fmt.Sprintf("https://t.me/c/%s/%d", id, messageID)
+	}
+	return ""
+}
+
+func saveLogSearch(chatID int64, text string) (int64, error) {
+	now := time.Now()
+	if _, err := statsDB.Exec("DELETE FROM log_searches WHERE created_at < ?", now.Add(-logSearchTTL).Unix()); err != nil {
+		return 0, err
+	}
+	res, err := statsDB.Exec("INSERT INTO log_searches(chat_id, query, created_at) VALUES (?, ?, ?)", chatID, text, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func loadLogSearch(id int64) (int64, string, bool, error) {
+	var chatID int64
+	var text string
+	err := statsDB.QueryRow("SELECT chat_id, query FROM log_searches WHERE id = ?", id).Scan(&chatID, &text)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, "", false, nil
+		}
+		return 0, "", false, err
+	}
+	return chatID, text, true, nil
+}
+
+func formatLogSearchPage(chat models.Chat, hits []logMessage, truncated bool, searchID int64, page int) (string, *models.InlineKeyboardMarkup) {
+	pages := (len(hits) + logSearchPageSize - 1) / logSearchPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	start := page * logSearchPageSize
+	end := start + logSearchPageSize
+	if end > len(hits) {
+		end = len(hits)
+	}
+
+	msg := fmt.Sprintf("Найдено в логах [%d]:\n", len(hits))
+	if truncated {
+		msg = fmt.Sprintf("Найдено в логах больше %d, показаны последние:\n", logSearchLimit)
+	}
+	for _, hit := range hits[start:end] {
+		text := hit.text
+		if runes := []rune(text); len(runes) > logSearchMaxText {
+			text = string(runes[:logSearchMaxText]) + "…"
+		}
+		msg += fmt.Sprintf("[%s] %s: %s\n", hit.time.In(time.Local).Format(editTimeLayout), hit.author, text)
+		if link := getMessageLink(chat, hit.messageID); link != "" {
+			msg += link + "\n"
+		}
+	}
+	if pages <= 1 {
+		return msg, nil
+	}
+	msg += fmt.Sprintf("Страница %d из %d", page+1, pages)
+
+	var buttons []models.InlineKeyboardButton
+	if page > 0 {
+		buttons = append(buttons, models.InlineKeyboardButton{Text: "◀ Назад", CallbackData: fmt.Sprintf("%s%d:%d", logSearchPrefix, searchID, page-1)})
+	}
+	if page < pages-1 {
+		buttons = append(buttons, models.InlineKeyboardButton{Text: "Дальше ▶", CallbackData: fmt.Sprintf("%s%d:%d", logSearchPrefix, searchID, page+1)})
+	}
+	return msg, &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{buttons}}
+}
+
+func handleLogSearch(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle log search")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	searchText := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "!лог"))
+	query := parseLogSearchQuery(searchText)
+	if query.invalid != "" {
+		sendText(ctx, b, update, "Странная дата "+query.invalid+", нужно 2024-01-01 или 2024-01-01..2024-01-31")
+		return
+	}
+	if len(query.terms) == 0 && query.user == "" {
+		sendText(ctx, b, update, "Нужно указать текст для поиска: !лог <текст> [2024-01-01..2024-01-31] [@ник]")
+		return
+	}
+
+	chat := update.Message.Chat
+	hits, truncated, err := searchChatLogs(chat.ID, query)
+	if err != nil {
+		log.Println("Can't search chat logs")
+		log.Println(err)
+		return
+	}
+	if len(hits) == 0 {
+		sendText(ctx, b, update, "В логах ничего не нашлось")
+		return
+	}
+
+	var searchID int64
+	if len(hits) > logSearchPageSize {
+		searchID, err = saveLogSearch(chat.ID, searchText)
+		if err != nil {
+			log.Println("Can't save log search")
+			log.Println(err)
+			return
+		}
+	}
+
+	msg, keyboard := formatLogSearchPage(chat, hits, truncated, searchID, 0)
+	params := &bot.SendMessageParams{
+		ChatID: chat.ID,
+		Text:   msg,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+	if update.Message.MessageThreadID != 0 {
+		params.MessageThreadID = update.Message.MessageThreadID
+	}
+	if _, err = b.SendMessage(ctx, params); err != nil {
+		log.Println("Can't send log search results")
+		log.Println(err)
+	}
+}
+
+func handleLogSearchPage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle log search page")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	msg := update.CallbackQuery.Message.Message
+	if msg == nil {
+		answerCallback(ctx, b, update, "Сообщение с поиском слишком старое")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(update.CallbackQuery.Data, logSearchPrefix), ":")
+	if len(parts) != 2 {
+		answerCallback(ctx, b, update, "Странная страница")
+		return
+	}
+	searchID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		answerCallback(ctx, b, update, "Странная страница")
+		return
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil {
+		answerCallback(ctx, b, update, "Странная страница")
+		return
+	}
+
+	chatID, searchText, found, err := loadLogSearch(searchID)
+	if err != nil {
+		log.Println("Can't load log search")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if !found || chatID != msg.Chat.ID {
+		answerCallback(ctx, b, update, "Поиск устарел, повтори !лог")
+		return
+	}
+
+	hits, truncated, err := searchChatLogs(chatID, parseLogSearchQuery(searchText))
+	if err != nil {
+		log.Println("Can't search chat logs")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+
+	text, keyboard := formatLogSearchPage(msg.Chat, hits, truncated, searchID, page)
+	params := &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+		LinkPreviewOptions: &models.LinkPreviewOptions{
+			IsDisabled: bot.True(),
+		},
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+	if _, err = b.EditMessageText(ctx, params); err != nil {
+		log.Println("Can't update log search page")
+		log.Println(err)
+	}
+	answerCallback(ctx, b, update, "")
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseLogSearchQuery(t *testing.T) {
+	day := func(value string) time.Time {
+		parsed, _ := time.ParseInLocation(dayLayout, value, time.Local)
+		return parsed
+	}
+	tests := []struct {
+		text string
+		want logSearchQuery
+	}{
+		{"", logSearchQuery{}},
+		{"Пёс  Барбос", logSearchQuery{terms: []string{"пес", "барбос"}}},
+		{"кот @Vasya", logSearchQuery{terms: []string{"кот"}, user: "vasya"}},
+		{"@ кот", logSearchQuery{terms: []string{"@", "кот"}}},
+		{"кот 2024-01-05", logSearchQuery{terms: []string{"кот"}, since: day("2024-01-05"), until: day("2024-01-06")}},
+		{"кот 2024-01-01..2024-01-31", logSearchQuery{terms: []string{"кот"}, since: day("2024-01-01"), until: day("2024-02-01")}},
+		{"кот 2024-01-31..2024-01-01", logSearchQuery{terms: []string{"кот"}, invalid: "2024-01-31..2024-01-01"}},
+		{"кот 2024-02-30", logSearchQuery{terms: []string{"кот"}, invalid: "2024-02-30"}},
+		{"кот 2024-01-01..", logSearchQuery{terms: []string{"кот"}, invalid: "2024-01-01.."}},
+		{"версия 1.2.3", logSearchQuery{terms: []string{"версия", "1.2.3"}}},
+	}
+	for _, test := range tests {
+		if got := parseLogSearchQuery(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseLogSearchQuery(%q) = %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
+
+func TestParseTextLogEntry(t *testing.T) {
+	at := func(clock string) time.Time {
+		parsed, _ := time.ParseInLocation(logTimeLayout, clock, time.Local)
+		return time.Date(2024, 3, 1, parsed.Hour(), parsed.Minute(), parsed.Second(), 0, time.Local)
+	}
+	tests := []struct {
+		line string
+		want logMessage
+	}{
+		{
+			"[10:00:00] [#5] [bob] hello",
+			logMessage{time: at("10:00:00"), author: "bob", username: "bob", text: "hello", messageID: 5},
+		},
+		{
+			"[10:00:00] [bob] written before the IDs",
+			logMessage{time: at("10:00:00"), author: "bob", username: "bob", text: "written before the IDs"},
+		},
+		{
+			"[11:00:00] [#5] [bob] [edit of 10:00:00] hello there",
+			logMessage{time: at("10:00:00"), editTime: at("11:00:00"), author: "bob", username: "bob", text: "hello there", messageID: 5},
+		},
+		{
+			"[11:00:00] [bob] [edit of 10:00:00 #5] old edit line",
+			logMessage{time: at("10:00:00"), editTime: at("11:00:00"), author: "bob", username: "bob", text: "old edit line", messageID: 5},
+		},
+		{
+			"[00:01:00] [#7] [Bob X] [edit of 23:59:00] from yesterday",
+			logMessage{time: at("23:59:00").AddDate(0, 0, -1), editTime: at("00:01:00"), author: "Bob X", username: "Bob X", text: "from yesterday", messageID: 7},
+		},
+		{
+			"[10:00:00] [#bob] not an ID",
+			logMessage{time: at("10:00:00"), author: "#bob", username: "#bob", text: "not an ID"},
+		},
+		{
+			"[10:00:00] [#5] [bob] [edit of lunch] is text",
+			logMessage{time: at("10:00:00"), author: "bob", username: "bob", text: "[edit of lunch] is text", messageID: 5},
+		},
+		{
+			"[10:00:00] [#5] [bob] first\nsecond line",
+			logMessage{time: at("10:00:00"), author: "bob", username: "bob", text: "first\nsecond line", messageID: 5},
+		},
+	}
+	for _, test := range tests {
+		entry := logEntry{time: at(test.line[1 : len(logTimeLayout)+1]), text: test.line}
+		if got := parseTextLogEntry(entry); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseTextLogEntry(%q) = %+v, want %+v", test.line, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,382 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	chatLogQueueSize     = 256
+	chatLogFlushInterval = time.Second
+	// A writer quiet for this long closes its files and stops, the next line
+	// of the chat starts a new one.
+	chatLogIdleTimeout = 10 * time.Minute
+)
+
+type chatLogLine struct {
+	chat     models.Chat
+	fileName string
+	text     string
+	at       time.Time
+}
+
+type openLogFile struct {
+	file   *os.File
+	writer *bufio.Writer
+}
+
+// chatLogWriter owns the log files of one chat. Lines are buffered and
+// flushed every second, files are reopened when the day changes and the
+// previous day is compressed.
+type chatLogWriter struct {
+	chatID int64
+	lines  chan chatLogLine
+	// mu guards closed, senders hold it for reading so the channel isn't
+	// closed under them and a full queue only waits for its own chat.
+	mu         sync.RWMutex
+	closed     bool
+	day        string
+	chatName   string
+	files      map[string]*openLogFile
+	lastLineAt time.Time
+}
+
+var (
+	chatLogWritersMu sync.Mutex
+	chatLogWriters   = make(map[int64]*chatLogWriter)
+	chatLogWritersWG sync.WaitGroup
+	chatLogsClosed   bool
+)
+
+// writeChatLog queues the line for the chat log file, the call doesn't wait
+// for the disk.
+func writeChatLog(chat models.Chat, fileName string, text string, at time.Time) {
+	for {
+		chatLogWritersMu.Lock()
+		if chatLogsClosed {
+			chatLogWritersMu.Unlock()
+			log.Println("Chat logs are closed, line dropped")
+			return
+		}
+
+		writer, exists := chatLogWriters[chat.ID]
+		if !exists {
+			writer = &chatLogWriter{
+				chatID:     chat.ID,
+				lines:      make(chan chatLogLine, chatLogQueueSize),
+				files:      make(map[string]*openLogFile),
+				lastLineAt: time.Now(),
+			}
+			chatLogWriters[chat.ID] = writer
+			chatLogWritersWG.Add(1)
+			go writer.run()
+		}
+		chatLogWritersMu.Unlock()
+
+		writer.mu.RLock()
+		if !writer.closed {
+			writer.lines <- chatLogLine{chat: chat, fileName: fileName, text: text, at: at}
+			writer.mu.RUnlock()
+			return
+		}
+		// The writer went idle in between, the next loop starts a new one.
+		writer.mu.RUnlock()
+	}
+}
+
+// closeChatLogs flushes and closes all chat logs, lines written after that
+// are dropped.
+func closeChatLogs() {
+	chatLogWritersMu.Lock()
+	chatLogsClosed = true
+	writers := make([]*chatLogWriter, 0, len(chatLogWriters))
+	for _, writer := range chatLogWriters {
+		writers = append(writers, writer)
+	}
+	chatLogWritersMu.Unlock()
+
+	for _, writer := range writers {
+		writer.mu.Lock()
+		if !writer.closed {
+			writer.closed = true
+			close(writer.lines)
+		}
+		writer.mu.Unlock()
+	}
+	chatLogWritersWG.Wait()
+}
+
+func (w *chatLogWriter) run() {
+	defer chatLogWritersWG.Done()
+
+	ticker := time.NewTicker(chatLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case line, ok := <-w.lines:
+			if !ok {
+				w.closeFiles()
+				return
+			}
+			w.lastLineAt = time.Now()
+			w.write(line)
+		case <-ticker.C:
+			w.flush()
+			if time.Since(w.lastLineAt) >= chatLogIdleTimeout && w.stopIdle() {
+				return
+			}
+		}
+	}
+}
+
+// stopIdle closes the files and removes the writer from the map. It gives up
+// when a sender holds the writer, a sender may wait for the queue this
+// goroutine reads.
+func (w *chatLogWriter) stopIdle() bool {
+	if !w.mu.TryLock() {
+		return false
+	}
+	defer w.mu.Unlock()
+	if w.closed {
+		// closeChatLogs got here first and closed the queue.
+		return false
+	}
+	w.closed = true
+
+	// No sender is inside, the lines already queued are the last ones. The
+	// writer stays in the map until its files are closed, so a new one for
+	// the chat doesn't write next to it.
+drain:
+	for {
+		select {
+		case line := <-w.lines:
+			w.write(line)
+		default:
+			break drain
+		}
+	}
+	w.closeFiles()
+
+	chatLogWritersMu.Lock()
+	delete(chatLogWriters, w.chatID)
+	chatLogWritersMu.Unlock()
+	return true
+}
+
+func (w *chatLogWriter) write(line chatLogLine) {
+	day := line.at.Format(dayLayout)
+	name := getChatLogName(line.chat)
+	if day != w.day || name != w.chatName {
+		w.closeFiles()
+		// The alias index is shared by the writers of all chats.
+		logsMu.Lock()
+		registerLogAlias(line.chat)
+		logsMu.Unlock()
+		if day != w.day {
+			if w.day != "" {
+				compressLogDay(w.chatID, w.day)
+			} else {
+				compressPreviousLogDay(w.chatID, line.at)
+			}
+		}
+		w.day = day
+		w.chatName = name
+	}
+
+	f, err := w.open(line.fileName, line.at)
+	if err != nil {
+		log.Printf("Can't open log %s of chat %d", line.fileName, w.chatID)
+		log.Println(err)
+		return
+	}
+	if _, err = f.writer.WriteString(line.text); err != nil {
+		log.Printf("Can't write log %s of chat %d", line.fileName, w.chatID)
+		log.Println(err)
+		w.closeFile(line.fileName)
+	}
+}
+
+func (w *chatLogWriter) open(fileName string, at time.Time) (*openLogFile, error) {
+	if f, exists := w.files[fileName]; exists {
+		return f, nil
+	}
+
+	logsDir, err := getLogsDayDir(w.chatID, at)
+	if err != nil {
+		return nil, err
+	}
+	if err = os.MkdirAll(logsDir, 0755); err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(filepath.Join(logsDir, fileName), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	f := &openLogFile{file: file, writer: bufio.NewWriter(file)}
+	w.files[fileName] = f
+	return f, nil
+}
+
+func (w *chatLogWriter) flush() {
+	for fileName, f := range w.files {
+		if err := f.writer.Flush(); err != nil {
+			log.Printf("Can't flush log %s of chat %d", fileName, w.chatID)
+			log.Println(err)
+			w.closeFile(fileName)
+		}
+	}
+}
+
+func (w *chatLogWriter) closeFile(fileName string) {
+	f := w.files[fileName]
+	delete(w.files, fileName)
+	if err := f.writer.Flush(); err != nil {
+		log.Printf("Can't flush log %s of chat %d", fileName, w.chatID)
+		log.Println(err)
+	}
+	if err := f.file.Close(); err != nil {
+		log.Printf("Can't close log %s of chat %d", fileName, w.chatID)
+		log.Println(err)
+	}
+}
+
+func (w *chatLogWriter) closeFiles() {
+	for fileName := range w.files {
+		w.closeFile(fileName)
+	}
+}
+
+// compressLogDay gzips the chat log files of the day, a day that has ended
+// isn't written anymore.
+func compressLogDay(chatID int64, day string) {
+	date, err := time.ParseInLocation(dayLayout, day, time.Local)
+	if err != nil {
+		return
+	}
+	logsDir, err := getLogsDayDir(chatID, date)
+	if err != nil {
+		log.Println("Can't get logs directory")
+		log.Println(err)
+		return
+	}
+
+	entries, err := os.ReadDir(logsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Can't compress logs of chat %d for %s", chatID, day)
+			log.Println(err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || strings.HasSuffix(entry.Name(), ".gz") {
+			continue
+		}
+		path := filepath.Join(logsDir, entry.Name())
+		if err := gzipFile(path); err != nil {
+			log.Printf("Can't compress log %s", path)
+			log.Println(err)
+		}
+	}
+}
+
+// compressPreviousLogDay compresses the last day written before the writer
+// started, the bot may have been stopped when that day ended.
+func compressPreviousLogDay(chatID int64, now time.Time) {
+	days, err := listLogDays(chatID)
+	if err != nil {
+		log.Printf("Can't list logs of chat %d", chatID)
+		log.Println(err)
+		return
+	}
+	today := now.Format(dayLayout)
+	for _, day := range days {
+		if day.Format(dayLayout) < today {
+			compressLogDay(chatID, day.Format(dayLayout))
+			return
+		}
+	}
+}
+
+func gzipFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+".gz", os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err != nil {
+		zw.Close()
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err = zw.Close(); err != nil {
+		dst.Close()
+		os.Remove(path + ".gz")
+		return err
+	}
+	if err = dst.Close(); err != nil {
+		os.Remove(path + ".gz")
+		return err
+	}
+	return os.Remove(path)
+}
+
+type gzipReadCloser struct {
+	*gzip.Reader
+	file *os.File
+}
+
+func (r gzipReadCloser) Close() error {
+	r.Reader.Close()
+	return r.file.Close()
+}
+
+// openLogReader opens the log file or its compressed copy.
+func openLogReader(path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err == nil {
+		return f, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	gz, gzErr := os.Open(path + ".gz")
+	if gzErr != nil {
+		// Report the missing plain file, callers check os.IsNotExist.
+		return nil, err
+	}
+	zr, err := gzip.NewReader(gz)
+	if err != nil {
+		gz.Close()
+		return nil, err
+	}
+	return gzipReadCloser{Reader: zr, file: gz}, nil
+}
+
+func readLogFile(path string) ([]byte, error) {
+	r, err := openLogReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
@@ -33,7 +33,23 @@ func (c *updatesDebugHTTPClient) Do(req *http.Request) (*http.Response, error) {
 	return c.base.Do(req)
 }
 
+// matchCommand matches messages whose first word is the command, so "!q"
+// doesn't catch "!qauthors" the way a prefix match would.
+func matchCommand(command string) bot.MatchFunc {
+	return func(update *models.Update) bool {
+		if update == nil || update.Message == nil {
+			return false
+		}
+		parts := strings.Fields(update.Message.Text)
+		return len(parts) > 0 && parts[0] == command
+	}
+}
+
 func main() {
+	if len(os.Args) > 1 {
+		os.Exit(runCommand(os.Args[1:]))
+	}
+
 	log.Println("Start application")
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
@@ -44,6 +60,7 @@ func main() {
 			models.AllowedUpdateMessage,
 			models.AllowedUpdateEditedMessage,
 			models.AllowedUpdateCallbackQuery,
+			models.AllowedUpdateInlineQuery,
 			models.AllowedUpdateMessageReaction,
 			models.AllowedUpdateMessageReactionCount,
 		}),
@@ -54,6 +71,14 @@ func main() {
 
 	token := os.Getenv("TELEGRAM_API_TOKEN")
 
+	if format := os.Getenv("LOG_FORMAT"); format != "" {
+		if !isChatLogFormat(format) {
+			log.Println("Unknown LOG_FORMAT, use text, jsonl or both:", format)
+			os.Exit(1)
+		}
+		chatLogFormat = format
+	}
+
 	debug := os.Getenv("DEBUG")
 	if len(debug) != 0 {
 		opts = append(opts, bot.WithDebug())
@@ -74,10 +99,27 @@ func main() {
 	}
 
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!пиздец", bot.MatchTypeExact, handlePizdec)
-	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!q", bot.MatchTypePrefix, handleQ)
-	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!rq", bot.MatchTypeExact, handleRq)
+	goBotter.RegisterHandlerMatchFunc(matchCommand("!q"), handleQ)
+	goBotter.RegisterHandlerMatchFunc(matchCommand("!qauthors"), handleQAuthors)
+	goBotter.RegisterHandlerMatchFunc(matchCommand("!rq"), handleRq)
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!aq", bot.MatchTypePrefix, handleAq)
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!fq", bot.MatchTypePrefix, handleFq)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!dq", bot.MatchTypePrefix, handleDq)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!eq", bot.MatchTypePrefix, handleEq)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!uq", bot.MatchTypePrefix, handleUq)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!bindq", bot.MatchTypePrefix, handleBindQ)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!tq", bot.MatchTypeExact, handleTq)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!tags", bot.MatchTypeExact, handleTags)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!qimg", bot.MatchTypePrefix, handleQimg)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!qctx", bot.MatchTypePrefix, handleQctx)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!qotd", bot.MatchTypePrefix, handleQotd)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!modq", bot.MatchTypePrefix, handleModQ)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!exportq", bot.MatchTypePrefix, handleExportQ)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!importq", bot.MatchTypeExact, handleImportQ)
+	goBotter.RegisterHandler(bot.HandlerTypeCallbackQueryData, quoteVotePrefix, bot.MatchTypePrefix, handleQuoteVote)
+	goBotter.RegisterHandler(bot.HandlerTypeCallbackQueryData, quoteSearchPrefix, bot.MatchTypePrefix, handleQuoteSearchPage)
+	goBotter.RegisterHandler(bot.HandlerTypeCallbackQueryData, quoteDraftPrefix, bot.MatchTypePrefix, handleQuoteDraft)
+	goBotter.RegisterHandler(bot.HandlerTypeCallbackQueryData, quoteModerationPrefix, bot.MatchTypePrefix, handleQuoteModeration)
 
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!топдень", bot.MatchTypeExact, handleDayTop)
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!топ", bot.MatchTypeExact, handleTop)
@@ -90,13 +132,27 @@ func main() {
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!топреакт", bot.MatchTypeExact, handleReactionTop)
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!мояреак", bot.MatchTypeExact, handleMyReaction)
 	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!мойреак", bot.MatchTypeExact, handleMyReceivedReaction)
+	goBotter.RegisterHandler(bot.HandlerTypeMessageText, "!правки", bot.MatchTypeExact, handleEdits)
+	goBotter.RegisterHandlerMatchFunc(matchCommand("!лог"), handleLogSearch)
+	goBotter.RegisterHandlerMatchFunc(matchCommand("!логи"), handleLogSettings)
+	goBotter.RegisterHandlerMatchFunc(matchCommand("!seen"), handleSeen)
+	goBotter.RegisterHandler(bot.HandlerTypeCallbackQueryData, logSearchPrefix, bot.MatchTypePrefix, handleLogSearchPage)
 	goBotter.RegisterHandlerMatchFunc(func(update *models.Update) bool {
 		return update != nil && update.MessageReaction != nil
 	}, handleReactionUpdate)
 	goBotter.RegisterHandlerMatchFunc(func(update *models.Update) bool {
 		return update != nil && update.MessageReactionCount != nil
 	}, handleReactionCountUpdate)
+	goBotter.RegisterHandlerMatchFunc(func(update *models.Update) bool {
+		return update != nil && update.InlineQuery != nil
+	}, handleInlineQuery)
+
+	go runQuoteOfDayScheduler(ctx, goBotter)
+	go runLogRetention(ctx)
 
 	log.Println("Start bot")
 	goBotter.Start(ctx)
+
+	log.Println("Flush chat logs")
+	closeChatLogs()
 }
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	quoteModerationPrefix    = "qm:"
+	quoteModerationListLimit = 10
+)
+
+func isQuoteModerationEnabled(chatID int64) (bool, error) {
+	var count int
+	err := statsDB.QueryRow("SELECT COUNT(1) FROM quote_moderation WHERE chat_id = ?", chatID).Scan(&count)
+	return count > 0, err
+}
+
+func saveQuoteSubmission(chatID int64, channel string, q Quote) (int64, error) {
+	res, err := statsDB.Exec(`
+		INSERT INTO quote_submissions(chat_id, chat, quote_date, added_by, author, text, tags, created_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, chatID, channel, q.createdAt, q.addedBy, q.author, q.quote, strings.Join(q.tags, " "), time.Now().Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+type quoteSubmission struct {
+	id      int64
+	channel string
+	quote   Quote
+}
+
+func loadQuoteSubmissions(where string, args ...any) ([]quoteSubmission, error) {
+	rows, err := statsDB.Query("SELECT id, chat, quote_date, added_by, author, text, tags FROM quote_submissions WHERE "+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var submissions []quoteSubmission
+	for rows.Next() {
+		var item quoteSubmission
+		var tags string
+		if err = rows.Scan(&item.id, &item.channel, &item.quote.createdAt, &item.quote.addedBy, &item.quote.author, &item.quote.quote, &tags); err != nil {
+			return nil, err
+		}
+		item.quote.date = formatQuoteDate(item.quote.createdAt)
+		item.quote.tags = strings.Fields(tags)
+		submissions = append(submissions, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return submissions, nil
+}
+
+func formatQuoteSubmission(submission quoteSubmission) string {
+	q := submission.quote
+	text := fmt.Sprintf("Цитата на модерации [%d]:\n[%s] %s: %s", submission.id, q.date, q.author, q.quote)
+	if len(q.tags) > 0 {
+		text += " #" + strings.Join(q.tags, " #")
+	}
+	return text
+}
+
+func quoteModerationKeyboard(submissionID int64) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "✅ Одобрить", CallbackData: fmt.Sprintf("%s%d:1", quoteModerationPrefix, submissionID)},
+				{Text: "❌ Отклонить", CallbackData: fmt.Sprintf("%s%d:0", quoteModerationPrefix, submissionID)},
+			},
+		},
+	}
+}
+
+func postQuoteSubmission(ctx context.Context, b *bot.Bot, chatID int64, submission quoteSubmission) {
+	if _, err := b.SendMessage(ctx, &bot.SendMessageParams{
+		ChatID:      chatID,
+		Text:        formatQuoteSubmission(submission),
+		ReplyMarkup: quoteModerationKeyboard(submission.id),
+	}); err != nil {
+		log.Println("Can't post quote submission")
+		log.Println(err)
+	}
+}
+
+// addQuote saves the quote right away or, when the chat moderates quotes,
+// puts it into the queue for administrators. The quote gets its public ID
+// only after approval. It returns the reply for the user.
+func addQuote(ctx context.Context, b *bot.Bot, chatID int64, channel string, q Quote) (string, error) {
+	moderated, err := isQuoteModerationEnabled(chatID)
+	if err != nil {
+		return "", err
+	}
+
+	if !moderated {
+		id, err := saveQuote(ctx, channel, q)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("Цитата добавлена под номером: %d", id), nil
+	}
+
+	submissionID, err := saveQuoteSubmission(chatID, channel, q)
+	if err != nil {
+		return "", err
+	}
+	q.date = formatQuoteDate(q.createdAt)
+	postQuoteSubmission(ctx, b, chatID, quoteSubmission{id: submissionID, channel: channel, quote: q})
+	return "Цитата отправлена на модерацию, её номер появится после одобрения", nil
+}
+
+func parseQuoteModeration(data string) (int64, bool, bool) {
+	parts := strings.Split(strings.TrimPrefix(data, quoteModerationPrefix), ":")
+	if len(parts) != 2 || (parts[1] != "0" && parts[1] != "1") {
+		return 0, false, false
+	}
+	id, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, false, false
+	}
+	return id, parts[1] == "1", true
+}
+
+func handleQuoteModeration(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote moderation")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	msg := update.CallbackQuery.Message.Message
+	if msg == nil {
+		answerCallback(ctx, b, update, "Сообщение слишком старое")
+		return
+	}
+
+	submissionID, approved, ok := parseQuoteModeration(update.CallbackQuery.Data)
+	if !ok {
+		answerCallback(ctx, b, update, "Странный ответ")
+		return
+	}
+
+	if !isChatAdmin(ctx, b, msg.Chat, update.CallbackQuery.From.ID) {
+		answerCallback(ctx, b, update, "Модерировать цитаты могут только администраторы чата")
+		return
+	}
+
+	submissions, err := loadQuoteSubmissions("id = ? AND chat_id = ?", submissionID, msg.Chat.ID)
+	if err != nil {
+		log.Println("Can't load quote submission")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if len(submissions) == 0 {
+		answerCallback(ctx, b, update, "Заявка не найдена")
+		return
+	}
+	submission := submissions[0]
+
+	status := "rejected"
+	if approved {
+		status = "approved"
+	}
+	// Only one administrator wins when several press the buttons at once.
+	res, err := statsDB.Exec(`
+		UPDATE quote_submissions SET status = ?, reviewed_by = ?, reviewed_at = ?
+		WHERE id = ? AND status = 'pending'
+	`, status, update.CallbackQuery.From.ID, time.Now().Unix(), submissionID)
+	if err != nil {
+		log.Println("Can't update quote submission")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if changed, _ := res.RowsAffected(); changed == 0 {
+		answerCallback(ctx, b, update, "По этой цитате уже приняли решение")
+		return
+	}
+
+	text := formatQuoteSubmission(submission) + "\n\nОтклонена"
+	if approved {
+		id, err := saveQuote(ctx, submission.channel, submission.quote)
+		if err != nil {
+			log.Println("Can't save approved quote")
+			log.Println(err)
+			if _, err = statsDB.Exec("UPDATE quote_submissions SET status = 'pending', reviewed_by = 0, reviewed_at = 0 WHERE id = ?", submissionID); err != nil {
+				log.Println("Can't return quote submission to the queue")
+				log.Println(err)
+			}
+			answerCallback(ctx, b, update, "Что-то пошло не так и цитата не созранилась")
+			return
+		}
+		if _, err = statsDB.Exec("UPDATE quote_submissions SET quote_id = ? WHERE id = ?", id, submissionID); err != nil {
+			log.Println("Can't save approved quote ID")
+			log.Println(err)
+		}
+		text = fmt.Sprintf("%s\n\nОдобрена, номер цитаты: %d", formatQuoteSubmission(submission), id)
+	}
+
+	if _, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+	}); err != nil {
+		log.Println("Can't update quote submission message")
+		log.Println(err)
+	}
+	answerCallback(ctx, b, update, "")
+}
+
+func handleModQ(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote moderation settings")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	parts := strings.Fields(update.Message.Text)
+
+	if len(parts) < 2 {
+		moderated, err := isQuoteModerationEnabled(chatID)
+		if err != nil {
+			log.Println("Can't get quote moderation settings")
+			log.Println(err)
+			return
+		}
+		var pending int
+		if err = statsDB.QueryRow("SELECT COUNT(1) FROM quote_submissions WHERE chat_id = ? AND status = 'pending'", chatID).Scan(&pending); err != nil {
+			log.Println("Can't count pending quotes")
+			log.Println(err)
+			return
+		}
+		if moderated {
+			sendText(ctx, b, update, fmt.Sprintf("Цитаты добавляются после одобрения администраторами, ждут решения: %d\nПоказать очередь: !modq list, выключить: !modq off", pending))
+		} else {
+			sendText(ctx, b, update, fmt.Sprintf("Модерация цитат выключена, ждут решения: %d\nВключить: !modq on", pending))
+		}
+		return
+	}
+
+	if update.Message.From == nil || !isChatAdmin(ctx, b, update.Message.Chat, update.Message.From.ID) {
+		sendText(ctx, b, update, "Настраивать модерацию цитат могут только администраторы чата")
+		return
+	}
+
+	switch parts[1] {
+	case "on":
+		if _, err := statsDB.Exec("INSERT OR REPLACE INTO quote_moderation(chat_id, updated_at) VALUES (?, ?)", chatID, time.Now().Unix()); err != nil {
+			log.Println("Can't enable quote moderation")
+			log.Println(err)
+			return
+		}
+		sendText(ctx, b, update, "Модерация цитат включена, новые цитаты появятся после одобрения")
+	case "off":
+		if _, err := statsDB.Exec("DELETE FROM quote_moderation WHERE chat_id = ?", chatID); err != nil {
+			log.Println("Can't disable quote moderation")
+			log.Println(err)
+			return
+		}
+		sendText(ctx, b, update, "Модерация цитат выключена, уже отправленные цитаты остаются в очереди")
+	case "list":
+		submissions, err := loadQuoteSubmissions("chat_id = ? AND status = 'pending' ORDER BY id LIMIT ?", chatID, quoteModerationListLimit)
+		if err != nil {
+			log.Println("Can't load pending quotes")
+			log.Println(err)
+			return
+		}
+		if len(submissions) == 0 {
+			sendText(ctx, b, update, "Очередь модерации пуста")
+			return
+		}
+		for _, submission := range submissions {
+			postQuoteSubmission(ctx, b, chatID, submission)
+		}
+	default:
+		sendText(ctx, b, update, "Странная команда, можно: !modq on, !modq off или !modq list")
+	}
+}
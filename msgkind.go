@@ -0,0 +1,155 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-telegram/bot/models"
+)
+
+func formatDuration(seconds int) string {
+	return fmt.Sprintf("%d:%02d", seconds/60, seconds%60)
+}
+
+func joinUserNames(users []models.User) string {
+	names := make([]string, 0, len(users))
+	for i := range users {
+		names = append(names, getUserName(&users[i]))
+	}
+	return strings.Join(names, ", ")
+}
+
+// describeMessage returns the message kind, a short detail shown next to
+// the kind (file name, emoji, duration) and the text of the message: the
+// text itself, a caption or a description of a service message.
+func describeMessage(msg *models.Message) (string, string, string) {
+	if media := getMessageMedia(msg); media != nil {
+		detail := media.FileName
+		switch media.Type {
+		case "sticker":
+			detail = media.Emoji
+		case "voice", "video_note":
+			detail = formatDuration(media.Duration)
+		case "audio":
+			detail = strings.Trim(media.Performer+" - "+media.Title, " -")
+			if detail == "" {
+				detail = media.FileName
+			}
+		}
+		return media.Type, detail, msg.Caption
+	}
+
+	switch {
+	case msg.Text != "":
+		return "text", "", msg.Text
+	case msg.Poll != nil:
+		options := make([]string, 0, len(msg.Poll.Options))
+		for _, option := range msg.Poll.Options {
+			options = append(options, option.Text)
+		}
+		return "poll", "", msg.Poll.Question + " [" + strings.Join(options, " | ") + "]"
+	case msg.Contact != nil:
+		name := strings.TrimSpace(msg.Contact.FirstName + " " + msg.Contact.LastName)
+		return "contact", "", name + " " + msg.Contact.PhoneNumber
+	case msg.Venue != nil:
+		return "venue", "", msg.Venue.Title + ", " + msg.Venue.Address
+	case msg.Location != nil:
+		return "location", "", fmt.Sprintf("%.6f, %.6f", msg.Location.Latitude, msg.Location.Longitude)
+	case msg.Dice != nil:
+		return "dice", msg.Dice.Emoji, fmt.Sprintf("%d", msg.Dice.Value)
+	case msg.Game != nil:
+		return "game", "", msg.Game.Title
+	case msg.Story != nil:
+		return "story", "", ""
+	case msg.Checklist != nil:
+		return "checklist", "", msg.Checklist.Title
+	case msg.Giveaway != nil:
+		return "giveaway", "", ""
+	case msg.Invoice != nil:
+		return "invoice", "", msg.Invoice.Title
+	case len(msg.NewChatMembers) > 0:
+		return "joined", "", joinUserNames(msg.NewChatMembers)
+	case msg.LeftChatMember != nil:
+		return "left", "", getUserName(msg.LeftChatMember)
+	case msg.NewChatTitle != "":
+		return "title", "", msg.NewChatTitle
+	case len(msg.NewChatPhoto) > 0:
+		return "chat_photo", "", ""
+	case msg.DeleteChatPhoto:
+		return "chat_photo_deleted", "", ""
+	case msg.GroupChatCreated, msg.SupergroupChatCreated, msg.ChannelChatCreated:
+		return "chat_created", "", ""
+	case msg.PinnedMessage != nil:
+		text := ""
+		if msg.PinnedMessage.Message != nil {
+			text = formatMessageLogText(msg.PinnedMessage.Message)
+		}
+		return "pinned", "", text
+	case msg.MigrateToChatID != 0:
+		return "migrated", "", fmt.Sprintf("to %d", msg.MigrateToChatID)
+	case msg.MigrateFromChatID != 0:
+		return "migrated", "", fmt.Sprintf("from %d", msg.MigrateFromChatID)
+	case msg.MessageAutoDeleteTimerChanged != nil:
+		return "auto_delete_timer", "", fmt.Sprintf("%d", msg.MessageAutoDeleteTimerChanged.MessageAutoDeleteTime)
+	case msg.ForumTopicCreated != nil:
+		return "topic_created", "", msg.ForumTopicCreated.Name
+	case msg.ForumTopicEdited != nil:
+		return "topic_edited", "", msg.ForumTopicEdited.Name
+	case msg.ForumTopicClosed != nil:
+		return "topic_closed", "", ""
+	case msg.ForumTopicReopened != nil:
+		return "topic_reopened", "", ""
+	case msg.VoiceChatScheduled != nil:
+		return "video_chat_scheduled", "", formatLogTime(msg.VoiceChatScheduled.StartDate)
+	case msg.VoiceChatStarted != nil:
+		return "video_chat_started", "", ""
+	case msg.VoiceChatEnded != nil:
+		return "video_chat_ended", "", formatDuration(msg.VoiceChatEnded.Duration)
+	case msg.VoiceChatParticipantsInvited != nil:
+		return "video_chat_invited", "", joinUserNames(msg.VoiceChatParticipantsInvited.Users)
+	case msg.BoostAdded != nil:
+		return "boost", "", fmt.Sprintf("%d", msg.BoostAdded.BoostCount)
+	case msg.WriteAccessAllowed != nil:
+		return "write_access_allowed", "", ""
+	case msg.SuccessfulPayment != nil:
+		return "payment", "", fmt.Sprintf("%d %s", msg.SuccessfulPayment.TotalAmount, msg.SuccessfulPayment.Currency)
+	case msg.UsersShared != nil:
+		return "users_shared", "", ""
+	case msg.ChatShared != nil:
+		return "chat_shared", "", ""
+	case msg.WebAppData != nil:
+		return "web_app_data", "", msg.WebAppData.Data
+	case msg.ProximityAlertTriggered != nil:
+		return "proximity_alert", "", ""
+	}
+	return "unknown", "", ""
+}
+
+// formatMessageLogText renders the message for the plain text log, plain
+// texts are kept as is and everything else gets a "[kind: detail]" label.
+func formatMessageLogText(msg *models.Message) string {
+	kind, detail, text := describeMessage(msg)
+	if kind == "text" {
+		return text
+	}
+
+	label := "[" + kind
+	if detail != "" {
+		label += ": " + detail
+	}
+	label += "]"
+	if text != "" {
+		label += " " + text
+	}
+	return label
+}
+
+// getLogAuthor returns the username written to the plain text log, users
+// without a username and channel posts fall back to the display name.
+func getLogAuthor(msg *models.Message) string {
+	if msg.From != nil && msg.From.Username != "" {
+		return msg.From.Username
+	}
+	_, name, _ := getMessageAuthor(msg)
+	return name
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	quoteContextLines      = 5
+	quoteContextMatchRange = 2 * time.Minute
+	quoteContextMaxLine    = 300
+	logTimeLayout          = "15:04:05"
+)
+
+// logEntry is one message from a plain text log file, continuation lines of
+// multiline messages are glued to the message they belong to.
+type logEntry struct {
+	time time.Time
+	text string
+}
+
+func readLogEntries(path string, day time.Time) ([]logEntry, error) {
+	f, err := openLogReader(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []logEntry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if len(line) > len(logTimeLayout)+2 && line[0] == '[' && line[len(logTimeLayout)+1] == ']' {
+			clock, err := time.ParseInLocation(logTimeLayout, line[1:len(logTimeLayout)+1], time.Local)
+			if err == nil {
+				entryTime := time.Date(day.Year(), day.Month(), day.Day(), clock.Hour(), clock.Minute(), clock.Second(), 0, time.Local)
+				entries = append(entries, logEntry{time: entryTime, text: line})
+				continue
+			}
+		}
+		if len(entries) > 0 {
+			entries[len(entries)-1].text += "\n" + line
+		}
+	}
+	return entries, scanner.Err()
+}
+
+// findQuoteLogEntry returns the log message the quote was taken from. A
+// message containing the quote text close to the quote time wins, otherwise
+// the first message written at or after the quote time is used.
+func findQuoteLogEntry(messages []logMessage, q Quote) int {
+	quoteTime := time.Unix(q.createdAt, 0)
+	firstLine := normalizeSearchText(strings.TrimSpace(strings.SplitN(q.quote, "\n", 2)[0]))
+
+	best := -1
+	for i, message := range messages {
+		if best < 0 && !message.time.Before(quoteTime) {
+			best = i
+		}
+		diff := message.time.Sub(quoteTime)
+		if diff < -quoteContextMatchRange || diff > quoteContextMatchRange || firstLine == "" {
+			continue
+		}
+		if strings.Contains(normalizeSearchText(message.text), firstLine) {
+			return i
+		}
+	}
+	if best < 0 {
+		best = len(messages) - 1
+	}
+	return best
+}
+
+// formatLogContextLine writes the message the way the text log does, so the
+// context looks the same whatever LOG_FORMAT is.
+func formatLogContextLine(message logMessage) string {
+	if message.editTime.IsZero() {
+		return fmt.Sprintf("[%s] [%s] %s", message.time.Format(logTimeLayout), message.author, message.text)
+	}
+	return fmt.Sprintf("[%s] [%s] [edit of %s] %s", message.editTime.Format(logTimeLayout), message.author, message.time.Format(logTimeLayout), message.text)
+}
+
+func formatLogContext(messages []logMessage, center int) string {
+	start := center - quoteContextLines
+	if start < 0 {
+		start = 0
+	}
+	end := center + quoteContextLines + 1
+	if end > len(messages) {
+		end = len(messages)
+	}
+
+	var result strings.Builder
+	for i := start; i < end; i++ {
+		text := formatLogContextLine(messages[i])
+		if runes := []rune(text); len(runes) > quoteContextMaxLine {
+			text = string(runes[:quoteContextMaxLine]) + "…"
+		}
+		if i == center {
+			result.WriteString("➡️ ")
+		}
+		result.WriteString(text)
+		result.WriteString("\n")
+	}
+	return result.String()
+}
+
+func handleQctx(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote context")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	id, problem := parseQuoteIdArg(strings.Fields(update.Message.Text))
+	if problem != "" {
+		sendText(ctx, b, update, problem)
+		return
+	}
+
+	q, found, err := loadQuote(getQuoteChannel(update.Message.Chat.ID), id)
+	if err != nil {
+		log.Println("Can't load quote for context")
+		log.Println(err)
+		return
+	}
+	if !found {
+		sendText(ctx, b, update, fmt.Sprintf("Цитата с номером %d не найдена", id))
+		return
+	}
+	if q.createdAt == 0 {
+		sendText(ctx, b, update, "У этой цитаты нет даты, контекст не найти")
+		return
+	}
+
+	day := time.Unix(q.createdAt, 0).In(time.Local)
+	messages, err := readLogMessages(update.Message.Chat.ID, day)
+	if err != nil {
+		log.Println("Can't read logs for quote context")
+		log.Println(err)
+		return
+	}
+	if len(messages) == 0 {
+		sendText(ctx, b, update, "Лога за "+day.Format(dayLayout)+" нет, контекст не найти")
+		return
+	}
+
+	center := findQuoteLogEntry(messages, q)
+	sendText(ctx, b, update, fmt.Sprintf("Цитата %d, %s:\n%s", q.id, day.Format(dayLayout), formatLogContext(messages, center)))
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/jpeg"
+	"image/png"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/font/gofont/goitalic"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	quoteCardWidth    = 800
+	quoteCardPadding  = 48
+	quoteCardAvatar   = 72
+	quoteCardMaxLines = 40
+)
+
+var (
+	quoteCardBackground = color.RGBA{0x1f, 0x23, 0x2b, 0xff}
+	quoteCardAccent     = color.RGBA{0xf0, 0xa0, 0x30, 0xff}
+	quoteCardText       = color.RGBA{0xee, 0xee, 0xee, 0xff}
+	quoteCardMuted      = color.RGBA{0x90, 0x96, 0xa0, 0xff}
+)
+
+type quoteCardFaces struct {
+	author font.Face
+	date   font.Face
+	text   font.Face
+}
+
+func newQuoteCardFace(ttf []byte, size float64) (font.Face, error) {
+	f, err := opentype.Parse(ttf)
+	if err != nil {
+		return nil, err
+	}
+	return opentype.NewFace(f, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+func loadQuoteCardFaces() (quoteCardFaces, error) {
+	var faces quoteCardFaces
+	var err error
+	if faces.author, err = newQuoteCardFace(gobold.TTF, 30); err != nil {
+		return faces, err
+	}
+	if faces.date, err = newQuoteCardFace(goitalic.TTF, 20); err != nil {
+		return faces, err
+	}
+	if faces.text, err = newQuoteCardFace(goregular.TTF, 28); err != nil {
+		return faces, err
+	}
+	return faces, nil
+}
+
+// wrapQuoteText splits the text into lines fitting the width, words longer
+// than a line are broken by characters.
+func wrapQuoteText(face font.Face, text string, width int) []string {
+	var lines []string
+	for _, paragraph := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.FieldsFunc(paragraph, unicode.IsSpace) {
+			candidate := word
+			if line != "" {
+				candidate = line + " " + word
+			}
+			if font.MeasureString(face, candidate).Ceil() <= width {
+				line = candidate
+				continue
+			}
+			if line != "" {
+				lines = append(lines, line)
+				line = ""
+			}
+			for font.MeasureString(face, word).Ceil() > width {
+				runes := []rune(word)
+				cut := len(runes) - 1
+				for cut > 1 && font.MeasureString(face, string(runes[:cut])).Ceil() > width {
+					cut--
+				}
+				lines = append(lines, string(runes[:cut]))
+				word = string(runes[cut:])
+			}
+			line = word
+		}
+		lines = append(lines, line)
+	}
+
+	if len(lines) > quoteCardMaxLines {
+		lines = lines[:quoteCardMaxLines]
+		lines[quoteCardMaxLines-1] += " …"
+	}
+	return lines
+}
+
+func drawQuoteCardString(img draw.Image, face font.Face, c color.Color, x int, y int, text string) {
+	drawer := &font.Drawer{
+		Dst:  img,
+		Src:  image.NewUniform(c),
+		Face: face,
+		Dot:  fixed.P(x, y),
+	}
+	drawer.DrawString(text)
+}
+
+// circleMask is an alpha mask cutting a circle out of a square avatar.
+type circleMask struct {
+	size int
+}
+
+func (m circleMask) ColorModel() color.Model {
+	return color.AlphaModel
+}
+
+func (m circleMask) Bounds() image.Rectangle {
+	return image.Rect(0, 0, m.size, m.size)
+}
+
+func (m circleMask) At(x, y int) color.Color {
+	r := float64(m.size) / 2
+	dx := float64(x) + 0.5 - r
+	dy := float64(y) + 0.5 - r
+	if dx*dx+dy*dy <= r*r {
+		return color.Alpha{A: 0xff}
+	}
+	return color.Alpha{}
+}
+
+// renderQuoteCard draws the quote as a PNG picture, the avatar is optional.
+func renderQuoteCard(q Quote, avatar image.Image) ([]byte, error) {
+	faces, err := loadQuoteCardFaces()
+	if err != nil {
+		return nil, err
+	}
+	defer faces.author.Close()
+	defer faces.date.Close()
+	defer faces.text.Close()
+
+	textWidth := quoteCardWidth - 2*quoteCardPadding
+	lines := wrapQuoteText(faces.text, q.quote, textWidth)
+	lineHeight := faces.text.Metrics().Height.Ceil() + 6
+
+	headerHeight := quoteCardAvatar
+	footer := fmt.Sprintf("#%d", q.id)
+	if len(q.tags) > 0 {
+		footer += "   #" + strings.Join(q.tags, " #")
+	}
+	height := quoteCardPadding + headerHeight + 32 + len(lines)*lineHeight + 24 + faces.date.Metrics().Height.Ceil() + quoteCardPadding
+
+	img := image.NewRGBA(image.Rect(0, 0, quoteCardWidth, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(quoteCardBackground), image.Point{}, draw.Src)
+	draw.Draw(img, image.Rect(0, 0, 8, height), image.NewUniform(quoteCardAccent), image.Point{}, draw.Src)
+
+	nameX := quoteCardPadding
+	if avatar != nil {
+		scaled := image.NewRGBA(image.Rect(0, 0, quoteCardAvatar, quoteCardAvatar))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), avatar, avatar.Bounds(), xdraw.Src, nil)
+		avatarRect := image.Rect(quoteCardPadding, quoteCardPadding, quoteCardPadding+quoteCardAvatar, quoteCardPadding+quoteCardAvatar)
+		draw.DrawMask(img, avatarRect, scaled, image.Point{}, circleMask{size: quoteCardAvatar}, image.Point{}, draw.Over)
+		nameX += quoteCardAvatar + 20
+	}
+
+	author := q.author
+	if author == "" {
+		author = "Аноним"
+	}
+	drawQuoteCardString(img, faces.author, quoteCardAccent, nameX, quoteCardPadding+32, author)
+	drawQuoteCardString(img, faces.date, quoteCardMuted, nameX, quoteCardPadding+62, q.date)
+
+	y := quoteCardPadding + headerHeight + 32 + faces.text.Metrics().Ascent.Ceil()
+	for _, line := range lines {
+		drawQuoteCardString(img, faces.text, quoteCardText, quoteCardPadding, y, line)
+		y += lineHeight
+	}
+
+	drawQuoteCardString(img, faces.date, quoteCardMuted, quoteCardPadding, height-quoteCardPadding, footer)
+
+	var buf bytes.Buffer
+	if err = png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// loadQuoteAuthorAvatar finds the quote author among the chat members known
+// from the stats and downloads their profile picture. Dialogue quotes and
+// authors without a picture get no avatar.
+func loadQuoteAuthorAvatar(ctx context.Context, b *bot.Bot, chatID int64, author string) (image.Image, error) {
+	if author == "" || strings.Contains(author, ", ") {
+		return nil, nil
+	}
+
+	var userID int64
+	err := statsDB.QueryRow("SELECT user_id FROM stats_total WHERE chat_id = ? AND username = ? ORDER BY updated_at DESC LIMIT 1", chatID, author).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	photos, err := b.GetUserProfilePhotos(ctx, &bot.GetUserProfilePhotosParams{UserID: userID, Limit: 1})
+	if err != nil {
+		return nil, err
+	}
+	if len(photos.Photos) == 0 || len(photos.Photos[0]) == 0 {
+		return nil, nil
+	}
+
+	// Sizes go from the smallest, take the first one big enough for the card.
+	sizes := photos.Photos[0]
+	photo := sizes[len(sizes)-1]
+	for _, size := range sizes {
+		if size.Width >= quoteCardAvatar {
+			photo = size
+			break
+		}
+	}
+
+	data, err := downloadTelegramFile(ctx, b, photo.FileID)
+	if err != nil {
+		return nil, err
+	}
+	avatar, _, err := image.Decode(bytes.NewReader(data))
+	return avatar, err
+}
+
+func handleQimg(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote image")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	id, problem := parseQuoteIdArg(strings.Fields(update.Message.Text))
+	if problem != "" {
+		sendText(ctx, b, update, problem)
+		return
+	}
+
+	q, found, err := loadQuote(getQuoteChannel(update.Message.Chat.ID), id)
+	if err != nil {
+		log.Println("Can't load quote for image")
+		log.Println(err)
+		return
+	}
+	if !found {
+		sendText(ctx, b, update, fmt.Sprintf("Цитата с номером %d не найдена", id))
+		return
+	}
+
+	avatar, err := loadQuoteAuthorAvatar(ctx, b, update.Message.Chat.ID, q.author)
+	if err != nil {
+		log.Println("Can't load quote author avatar")
+		log.Println(err)
+		avatar = nil
+	}
+
+	card, err := renderQuoteCard(q, avatar)
+	if err != nil {
+		log.Println("Can't render quote image")
+		log.Println(err)
+		sendText(ctx, b, update, "Не получилось нарисовать цитату")
+		return
+	}
+
+	params := &bot.SendPhotoParams{
+		ChatID: update.Message.Chat.ID,
+		Photo: &models.InputFileUpload{
+			Filename: fmt.Sprintf("quote-%d.png", q.id),
+			Data:     bytes.NewReader(card),
+		},
+	}
+	if update.Message.MessageThreadID != 0 {
+		params.MessageThreadID = update.Message.MessageThreadID
+	}
+	if _, err = b.SendPhoto(ctx, params); err != nil {
+		log.Println("Can't send quote image")
+		log.Println(err)
+	}
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	quoteOfDayTimeLayout = "15:04"
+	// quoteOfDayInputLayout also takes 9:00 or 12:5, the time is stored
+	// normalized to quoteOfDayTimeLayout.
+	quoteOfDayInputLayout = "15:4"
+	// A failed post is retried by the next checks, the day is given up after
+	// this many attempts.
+	quoteOfDayMaxAttempts = 5
+)
+
+// quoteOfDayFailures counts the failed posts of today by chat ID, only the
+// scheduler goroutine uses it.
+var quoteOfDayFailures = make(map[int64]int)
+
+type quoteOfDaySetting struct {
+	chatID        int64
+	postTime      string
+	lastPostedDay string
+}
+
+func loadQuoteOfDaySettings() ([]quoteOfDaySetting, error) {
+	rows, err := statsDB.Query("SELECT chat_id, post_time, last_posted_day FROM quote_of_day")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var settings []quoteOfDaySetting
+	for rows.Next() {
+		var item quoteOfDaySetting
+		if err = rows.Scan(&item.chatID, &item.postTime, &item.lastPostedDay); err != nil {
+			return nil, err
+		}
+		settings = append(settings, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return settings, nil
+}
+
+// pickQuoteOfDay returns a random quote not posted in the chat yet, once the
+// whole base was posted the history is cleared and the cycle starts again.
+func pickQuoteOfDay(chatID int64, channel string) (Quote, bool, error) {
+	for attempt := 0; attempt < 2; attempt++ {
+		quotes, err := loadQuotes(`chat = ? AND quote_id NOT IN (
+			SELECT quote_id FROM quote_of_day_posted WHERE chat_id = ? AND chat = ?
+		) ORDER BY RANDOM() LIMIT 1`, channel, chatID, channel)
+		if err != nil {
+			return Quote{}, false, err
+		}
+		if len(quotes) > 0 {
+			return quotes[0], true, nil
+		}
+		if _, err = statsDB.Exec("DELETE FROM quote_of_day_posted WHERE chat_id = ?", chatID); err != nil {
+			return Quote{}, false, err
+		}
+	}
+	return Quote{}, false, nil
+}
+
+func postQuoteOfDay(ctx context.Context, b *bot.Bot, setting quoteOfDaySetting, today string) {
+	channel := getQuoteChannel(setting.chatID)
+	q, found, err := pickQuoteOfDay(setting.chatID, channel)
+	if err != nil {
+		log.Println("Can't pick quote of the day")
+		log.Println(err)
+		return
+	}
+
+	if found {
+		_, err = b.SendMessage(ctx, &bot.SendMessageParams{
+			ChatID:      setting.chatID,
+			Text:        formatQuotes([]Quote{q}),
+			ReplyMarkup: quoteVoteKeyboard(q),
+		})
+		switch {
+		case errors.Is(err, bot.ErrorForbidden):
+			// The bot was kicked or blocked, posting won't work anymore.
+			log.Printf("Can't post quote of the day to chat %d", setting.chatID)
+			log.Println(err)
+			delete(quoteOfDayFailures, setting.chatID)
+			disableQuoteOfDay(setting.chatID)
+			return
+		case err != nil:
+			log.Printf("Can't post quote of the day to chat %d", setting.chatID)
+			log.Println(err)
+			if ctx.Err() != nil {
+				// The bot is stopping, the post is retried after the restart.
+				return
+			}
+			quoteOfDayFailures[setting.chatID]++
+			if quoteOfDayFailures[setting.chatID] < quoteOfDayMaxAttempts {
+				return
+			}
+			log.Printf("Quote of the day for chat %d skipped after %d attempts", setting.chatID, quoteOfDayMaxAttempts)
+		default:
+			if _, err = statsDB.Exec(`
+				INSERT OR REPLACE INTO quote_of_day_posted(chat_id, chat, quote_id, posted_at)
+				VALUES (?, ?, ?, ?)
+			`, setting.chatID, channel, q.id, time.Now().Unix()); err != nil {
+				log.Println("Can't save posted quote of the day")
+				log.Println(err)
+			}
+		}
+	}
+
+	delete(quoteOfDayFailures, setting.chatID)
+	if _, err = statsDB.Exec("UPDATE quote_of_day SET last_posted_day = ?, updated_at = ? WHERE chat_id = ?", today, time.Now().Unix(), setting.chatID); err != nil {
+		log.Println("Can't save quote of the day state")
+		log.Println(err)
+	}
+}
+
+func disableQuoteOfDay(chatID int64) {
+	if _, err := statsDB.Exec("DELETE FROM quote_of_day WHERE chat_id = ?", chatID); err != nil {
+		log.Println("Can't disable quote of the day")
+		log.Println(err)
+		return
+	}
+	log.Printf("Quote of the day disabled for chat %d", chatID)
+}
+
+func checkQuoteOfDay(ctx context.Context, b *bot.Bot) {
+	settings, err := loadQuoteOfDaySettings()
+	if err != nil {
+		log.Println("Can't load quote of the day settings")
+		log.Println(err)
+		return
+	}
+
+	now := time.Now().In(time.Local)
+	today := now.Format(dayLayout)
+	currentTime := now.Format(quoteOfDayTimeLayout)
+	for _, setting := range settings {
+		if setting.lastPostedDay == today || currentTime < setting.postTime {
+			continue
+		}
+		postQuoteOfDay(ctx, b, setting, today)
+	}
+}
+
+// runQuoteOfDayScheduler checks every minute which chats are due for the
+// quote of the day. The last posted day is stored in the database, so a
+// restart neither skips nor repeats the daily post.
+func runQuoteOfDayScheduler(ctx context.Context, b *bot.Bot) {
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	checkQuoteOfDay(ctx, b)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkQuoteOfDay(ctx, b)
+		}
+	}
+}
+
+func handleQotd(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote of the day settings")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	parts := strings.Fields(update.Message.Text)
+
+	if len(parts) < 2 {
+		var postTime string
+		err := statsDB.QueryRow("SELECT post_time FROM quote_of_day WHERE chat_id = ?", chatID).Scan(&postTime)
+		if err != nil && err != sql.ErrNoRows {
+			log.Println("Can't get quote of the day settings")
+			log.Println(err)
+			return
+		}
+		if err == sql.ErrNoRows {
+			sendText(ctx, b, update, "Цитата дня выключена, включить: !qotd 12:00")
+			return
+		}
+		sendText(ctx, b, update, "Цитата дня публикуется каждый день в "+postTime+", выключить: !qotd off")
+		return
+	}
+
+	if update.Message.From == nil || !isChatAdmin(ctx, b, update.Message.Chat, update.Message.From.ID) {
+		sendText(ctx, b, update, "Настраивать цитату дня могут только администраторы чата")
+		return
+	}
+
+	if parts[1] == "off" {
+		if _, err := statsDB.Exec("DELETE FROM quote_of_day WHERE chat_id = ?", chatID); err != nil {
+			log.Println("Can't disable quote of the day")
+			log.Println(err)
+			return
+		}
+		sendText(ctx, b, update, "Цитата дня выключена")
+		return
+	}
+
+	postTime, err := time.Parse(quoteOfDayInputLayout, parts[1])
+	if err != nil {
+		sendText(ctx, b, update, "Странное время: "+parts[1]+", нужно указать как 12:00")
+		return
+	}
+
+	// Don't post right away when the time has already passed today.
+	now := time.Now().In(time.Local)
+	lastPostedDay := ""
+	if now.Format(quoteOfDayTimeLayout) >= postTime.Format(quoteOfDayTimeLayout) {
+		lastPostedDay = now.Format(dayLayout)
+	}
+
+	if _, err = statsDB.Exec(`
+		INSERT INTO quote_of_day(chat_id, post_time, last_posted_day, updated_at)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			post_time = excluded.post_time,
+			last_posted_day = MAX(quote_of_day.last_posted_day, excluded.last_posted_day),
+			updated_at = excluded.updated_at
+	`, chatID, postTime.Format(quoteOfDayTimeLayout), lastPostedDay, now.Unix()); err != nil {
+		log.Println("Can't save quote of the day settings")
+		log.Println(err)
+		return
+	}
+
+	sendText(ctx, b, update, fmt.Sprintf("Цитата дня будет публиковаться каждый день в %s", postTime.Format(quoteOfDayTimeLayout)))
+}
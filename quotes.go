@@ -3,11 +3,14 @@ package main
 import (
 	"bufio"
 	"context"
+	"database/sql"
 	"fmt"
+	"io"
 	"log"
 	"math/rand"
 	"os"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -16,45 +19,94 @@ import (
 	"github.com/go-telegram/bot/models"
 )
 
+const quoteDateLayout = "01/02/2006 15:04:05"
+
 type Quote struct {
-	id     int
-	date   string
-	author string
-	quote  string
+	id        int
+	date      string
+	author    string
+	quote     string
+	addedBy   int64
+	createdAt int64
+	score     int
+	tags      []string
 }
 
-func getQuoteFilePath(channel string) string {
+const quoteColumns = `quote_id, created_at, added_by, author, text,
+	(SELECT COALESCE(SUM(v.vote), 0) FROM quote_votes v WHERE v.chat = quotes.chat AND v.quote_id = quotes.quote_id) AS score,
+	(SELECT COALESCE(group_concat(t.tag, ' '), '') FROM quote_tags t WHERE t.chat = quotes.chat AND t.quote_id = quotes.quote_id) AS tags`
+
+func getQuotesDir() string {
 	ex, err := os.Executable()
 	if err != nil {
 		log.Fatal(err)
 	}
 	curDir := filepath.Dir(ex)
-	quotesFile := filepath.Join(curDir, "quotes", channel) + ".txt"
+	return filepath.Join(curDir, "quotes")
+}
+
+func getQuoteFilePath(channel string) string {
+	return filepath.Join(getQuotesDir(), channel) + ".txt"
+}
 
-	if _, err := os.Stat(quotesFile); os.IsNotExist(err) {
-		log.Printf("Quotes file %s not found\n", quotesFile)
-		return ""
+func isValidQuoteChannel(channel string) bool {
+	if channel == "" || channel == "." || channel == ".." {
+		return false
 	}
-	return quotesFile
+	return !strings.ContainsAny(channel, "/\\\x00")
 }
 
-func loadQuotes(channel string) []Quote {
-	log.Print("Loading quotes for channel ", channel)
+// getQuoteChannel returns the quote base used by the chat: the legacy
+// channel bound with !bindq or the chat ID for chats without a binding.
+func getQuoteChannel(chatID int64) string {
+	defaultChannel := strconv.FormatInt(chatID, 10)
+	// Private chats have positive IDs and can't be bound, bindings made
+	// before that was forbidden are ignored.
+	if statsDB == nil || chatID > 0 {
+		return defaultChannel
+	}
 
-	quotesFile := getQuoteFilePath(channel)
-	f, err := os.Open(quotesFile)
+	var channel string
+	err := statsDB.QueryRow("SELECT channel FROM quote_chat_binding WHERE chat_id = ?", chatID).Scan(&channel)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Can't get quote channel binding")
+			log.Println(err)
+		}
+		return defaultChannel
+	}
+	return channel
+}
 
+func parseQuoteDate(date string) int64 {
+	t, err := time.ParseInLocation(quoteDateLayout, date, time.Local)
 	if err != nil {
-		log.Fatal(err)
-		return []Quote{}
+		return 0
 	}
+	return t.Unix()
+}
 
+func formatQuoteDate(createdAt int64) string {
+	if createdAt == 0 {
+		return "?"
+	}
+	return time.Unix(createdAt, 0).In(time.Local).Format(quoteDateLayout)
+}
+
+// parseLegacyQuotes reads quotes in the eggdrop
+// "id date time channel author text" line format.
+func parseLegacyQuotes(r io.Reader) ([]Quote, error) {
 	var quotes []Quote
-	scanner := bufio.NewScanner(f)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
 
 	for scanner.Scan() {
 		line := scanner.Text()
 		res := strings.SplitN(line, " ", 6)
+		if len(res) < 6 {
+			log.Printf("Error parsing quote line: %s\n", line)
+			continue
+		}
 		id, err := strconv.Atoi(res[0])
 
 		if err != nil {
@@ -63,29 +115,198 @@ func loadQuotes(channel string) []Quote {
 		}
 
 		q := Quote{
-			id:     id,
-			date:   res[1] + " " + res[2],
-			author: res[4],
-			quote:  res[5],
+			id:        id,
+			date:      res[1] + " " + res[2],
+			author:    res[4],
+			quote:     res[5],
+			createdAt: parseQuoteDate(res[1] + " " + res[2]),
 		}
 		quotes = append(quotes, q)
 	}
-	f.Close()
+	return quotes, scanner.Err()
+}
+
+// loadLegacyQuotes reads quotes/<channel>.txt.
+func loadLegacyQuotes(channel string) []Quote {
+	log.Print("Loading legacy quotes for channel ", channel)
+
+	quotesFile := getQuoteFilePath(channel)
+	f, err := os.Open(quotesFile)
+
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Println("Can't open quotes file")
+			log.Println(err)
+		}
+		return []Quote{}
+	}
+	defer f.Close()
+
+	quotes, err := parseLegacyQuotes(f)
+	if err != nil {
+		log.Println("Can't read quotes file")
+		log.Println(err)
+	}
 	return quotes
 }
 
+func importLegacyQuotes(tx *sql.Tx, channel string) (int, error) {
+	imported := 0
+	for _, q := range loadLegacyQuotes(channel) {
+		res, err := tx.Exec(`
+			INSERT OR IGNORE INTO quotes(chat, quote_id, created_at, added_by, author, text)
+			VALUES (?, ?, ?, 0, ?, ?)
+		`, channel, q.id, q.createdAt, q.author, q.quote)
+		if err != nil {
+			return imported, err
+		}
+		if affected, err := res.RowsAffected(); err == nil && affected > 0 {
+			imported++
+		}
+	}
+	return imported, nil
+}
+
+// migrateQuoteSchema brings the quotes table to its current shape.
+func migrateQuoteSchema(db *sql.DB) error {
+	err := applyMigration(db, "v4_quotes_soft_delete", func(tx *sql.Tx) error {
+		if _, err := tx.Exec(`
+			ALTER TABLE quotes ADD COLUMN deleted_at INTEGER NOT NULL DEFAULT 0;
+			ALTER TABLE quotes ADD COLUMN deleted_by INTEGER NOT NULL DEFAULT 0;
+		`); err != nil {
+			return fmt.Errorf("can't add quote soft delete columns: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return applyMigration(db, "v5_quotes_search_text", func(tx *sql.Tx) error {
+		if _, err := tx.Exec("ALTER TABLE quotes ADD COLUMN search_text TEXT NOT NULL DEFAULT ''"); err != nil {
+			return fmt.Errorf("can't add quote search column: %w", err)
+		}
+		if err := fillQuoteSearchText(tx); err != nil {
+			return fmt.Errorf("can't fill quote search column: %w", err)
+		}
+		return nil
+	})
+}
+
+// importLegacyQuoteFiles imports the quote files into the database once.
+func importLegacyQuoteFiles(db *sql.DB) error {
+	return applyMigration(db, "v3_quotes_import", func(tx *sql.Tx) error {
+		files, err := filepath.Glob(filepath.Join(getQuotesDir(), "*.txt"))
+		if err != nil {
+			return fmt.Errorf("can't list legacy quote files: %w", err)
+		}
+		for _, file := range files {
+			channel := strings.TrimSuffix(filepath.Base(file), ".txt")
+			imported, err := importLegacyQuotes(tx, channel)
+			if err != nil {
+				return fmt.Errorf("can't import legacy quotes from %s: %w", file, err)
+			}
+			log.Printf("Imported %d legacy quotes for channel %s", imported, channel)
+		}
+		if err = fillQuoteSearchText(tx); err != nil {
+			return fmt.Errorf("can't fill quote search column: %w", err)
+		}
+		return nil
+	})
+}
+
+func scanQuotes(rows *sql.Rows) ([]Quote, error) {
+	defer rows.Close()
+
+	quotes := make([]Quote, 0, 10)
+	for rows.Next() {
+		var q Quote
+		var tags string
+		if err := rows.Scan(&q.id, &q.createdAt, &q.addedBy, &q.author, &q.quote, &q.score, &tags); err != nil {
+			return nil, err
+		}
+		q.date = formatQuoteDate(q.createdAt)
+		q.tags = strings.Fields(tags)
+		quotes = append(quotes, q)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return quotes, nil
+}
+
+func loadQuotes(query string, args ...any) ([]Quote, error) {
+	rows, err := statsDB.Query("SELECT "+quoteColumns+" FROM quotes WHERE deleted_at = 0 AND "+query, args...)
+	if err != nil {
+		return nil, err
+	}
+	return scanQuotes(rows)
+}
+
+func loadQuote(channel string, id int) (Quote, bool, error) {
+	quotes, err := loadQuotes("chat = ? AND quote_id = ?", channel, id)
+	if err != nil || len(quotes) == 0 {
+		return Quote{}, false, err
+	}
+	return quotes[0], true, nil
+}
+
+func countQuotes(channel string) (int, error) {
+	var count int
+	err := statsDB.QueryRow("SELECT COUNT(1) FROM quotes WHERE chat = ?", channel).Scan(&count)
+	return count, err
+}
+
+func saveQuote(ctx context.Context, channel string, q Quote) (int, error) {
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+
+	var nextId int
+	if err = tx.QueryRow("SELECT COALESCE(MAX(quote_id), 0) + 1 FROM quotes WHERE chat = ?", channel).Scan(&nextId); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if _, err = tx.Exec(`
+		INSERT INTO quotes(chat, quote_id, created_at, added_by, author, text, search_text)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, channel, nextId, q.createdAt, q.addedBy, q.author, q.quote, normalizeSearchText(q.quote)); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if err = saveQuoteTags(tx, channel, nextId, q.tags); err != nil {
+		_ = tx.Rollback()
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return nextId, nil
+}
+
 func formatQuotes(quotes []Quote) string {
 	var result string
 	for _, q := range quotes {
-		result += "[" + strconv.Itoa(q.id) + "] -> " + "[" + q.date + "] " + q.author + ": " + q.quote + "\n"
+		result += "[" + strconv.Itoa(q.id) + "] (" + fmt.Sprintf("%+d", q.score) + ") -> " + "[" + q.date + "] " + q.author + ": " + q.quote
+		if len(q.tags) > 0 {
+			result += " #" + strings.Join(q.tags, " #")
+		}
+		result += "\n"
 	}
 	return result
 
 }
 
 func handleQ(ctx context.Context, b *bot.Bot, update *models.Update) {
-	// loadQuotes(update.Message.Chat.Title)
-	quotes := loadQuotes("#nnm")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
 	parts := strings.Fields(update.Message.Text)
 
 	if len(parts) < 2 {
@@ -108,14 +329,15 @@ func handleQ(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 
-	for _, q := range quotes {
-		if q.id == id {
-			b.SendMessage(ctx, &bot.SendMessageParams{
-				ChatID: update.Message.Chat.ID,
-				Text:   formatQuotes([]Quote{q}),
-			})
-			return
-		}
+	q, found, err := loadQuote(getQuoteChannel(update.Message.Chat.ID), id)
+	if err != nil {
+		log.Println("Can't load quote")
+		log.Println(err)
+		return
+	}
+	if found {
+		sendQuote(ctx, b, update, q)
+		return
 	}
 	b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
@@ -123,22 +345,157 @@ func handleQ(ctx context.Context, b *bot.Bot, update *models.Update) {
 	})
 }
 
+const maxDialogueQuoteMessages = 10
+
+// loadDialogueQuote builds a quote from count consecutive messages starting
+// at the replied-to one, using the texts remembered by handleMsgToStats.
+func loadDialogueQuote(chatID int64, firstMessage *models.Message, count int) (Quote, error) {
+	rows, err := statsDB.Query(`
+		SELECT a.author_name, t.text, t.message_date
+		FROM message_text_state t
+		JOIN message_author_state a ON a.chat_id = t.chat_id AND a.message_id = t.message_id
+		WHERE t.chat_id = ? AND t.message_id >= ?
+		ORDER BY t.message_id
+		LIMIT ?
+	`, chatID, firstMessage.ID, count)
+	if err != nil {
+		return Quote{}, err
+	}
+	defer rows.Close()
+
+	var authors []string
+	var lines []string
+	quote := Quote{createdAt: int64(firstMessage.Date)}
+	for rows.Next() {
+		var author, text string
+		var date int64
+		if err = rows.Scan(&author, &text, &date); err != nil {
+			return Quote{}, err
+		}
+		if len(lines) == 0 {
+			quote.createdAt = date
+		}
+		lines = append(lines, "<"+author+"> "+text)
+		found := false
+		for _, a := range authors {
+			if a == author {
+				found = true
+				break
+			}
+		}
+		if !found {
+			authors = append(authors, author)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return Quote{}, err
+	}
+
+	quote.author = strings.Join(authors, ", ")
+	quote.quote = strings.Join(lines, "\n")
+	return quote, nil
+}
+
+// quoteFromReply turns the message replied to with "!aq [count]" into a quote
+// keeping its real author and date.
+func quoteFromReply(update *models.Update) (Quote, string) {
+	reply := update.Message.ReplyToMessage
+	text, _ := splitQuoteTags(update.Message.Text)
+	parts := strings.Fields(text)
+
+	count := 1
+	if len(parts) > 1 {
+		n, err := strconv.Atoi(parts[1])
+		if err != nil || n < 1 || n > maxDialogueQuoteMessages {
+			return Quote{}, fmt.Sprintf("Количество сообщений должно быть от 1 до %d", maxDialogueQuoteMessages)
+		}
+		count = n
+	}
+
+	if count == 1 {
+		text := getMessageText(reply)
+		_, author, hasAuthor := getMessageAuthor(reply)
+		if text == "" || !hasAuthor {
+			return Quote{}, "В сообщении нет текста для цитаты"
+		}
+		return Quote{
+			createdAt: int64(reply.Date),
+			author:    author,
+			quote:     text,
+		}, ""
+	}
+
+	quote, err := loadDialogueQuote(update.Message.Chat.ID, reply, count)
+	if err != nil {
+		log.Println("Can't load dialogue for quote")
+		log.Println(err)
+		return Quote{}, "Что-то пошло не так и цитата не созранилась"
+	}
+	if quote.quote == "" {
+		return Quote{}, "Не нашёл сообщений для цитаты, бот видит только сообщения после своего добавления"
+	}
+	return quote, ""
+}
+
+func isReplyQuoteCommand(text string) bool {
+	text, _ = splitQuoteTags(text)
+	parts := strings.Fields(text)
+	if len(parts) == 1 {
+		return true
+	}
+	if len(parts) == 2 {
+		_, err := strconv.Atoi(parts[1])
+		return err == nil
+	}
+	return false
+}
+
 func handleAq(ctx context.Context, b *bot.Bot, update *models.Update) {
-	currentTime := time.Now()
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	text, tags := splitQuoteTags(update.Message.Text)
 
-	quotes := loadQuotes("#nnm")
-	quote := Quote{
-		id:     quotes[len(quotes)-1].id + 1,
-		date:   currentTime.Format("01/02/2006 15:04:05"),
-		author: update.Message.From.Username,
-		quote:  update.Message.Text[4:],
+	var quote Quote
+	if update.Message.ReplyToMessage != nil && isReplyQuoteCommand(update.Message.Text) {
+		var problem string
+		quote, problem = quoteFromReply(update)
+		if problem != "" {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   problem,
+			})
+			return
+		}
+	} else {
+		if len(text) <= 4 {
+			b.SendMessage(ctx, &bot.SendMessageParams{
+				ChatID: update.Message.Chat.ID,
+				Text:   "Нужно указать текст цитаты или ответить командой на сообщение",
+			})
+			return
+		}
+
+		quote = Quote{
+			createdAt: time.Now().Unix(),
+			author:    getUserName(update.Message.From),
+			quote:     text[4:],
+		}
 	}
+	quote.addedBy = update.Message.From.ID
+	quote.tags = tags
 
-	quotesFile := getQuoteFilePath("#nnm")
-	f, err := os.OpenFile(quotesFile, os.O_APPEND|os.O_WRONLY, 0644)
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	if askDuplicateQuote(ctx, b, update, channel, quote) {
+		return
+	}
 
+	reply, err := addQuote(ctx, b, update.Message.Chat.ID, channel, quote)
 	if err != nil {
-		log.Fatal(err)
+		log.Println("Can't save quote")
+		log.Println(err)
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
 			Text:   "Что-то пошло не так и цитата не созранилась",
@@ -146,57 +503,518 @@ func handleAq(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 
-	_, err = f.WriteString(fmt.Sprintf("%d %s %s %s %s\n", quote.id, quote.date, "#nnm", quote.author, quote.quote))
-	if err != nil {
-		log.Fatal(err)
-		return
-	}
-	defer f.Close()
-
 	b.SendMessage(ctx, &bot.SendMessageParams{
 		ChatID: update.Message.Chat.ID,
-		Text:   fmt.Sprintf("Цитата добавлена под номером: %d", quote.id),
+		Text:   reply,
 	})
 }
 
+// quoteHasAuthor compares the author case-insensitively, dialogue quotes
+// match any of their participants.
+func quoteHasAuthor(q Quote, author string) bool {
+	author = normalizeSearchText(strings.TrimPrefix(author, "@"))
+	for _, name := range strings.Split(q.author, ", ") {
+		if normalizeSearchText(name) == author {
+			return true
+		}
+	}
+	return false
+}
+
 func handleRq(ctx context.Context, b *bot.Bot, update *models.Update) {
-	quotes := loadQuotes("#nnm")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
 
-	randId := rand.Intn(len(quotes))
-	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: update.Message.Chat.ID,
-		Text:   formatQuotes([]Quote{quotes[randId]}),
-	})
-}
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	parts := strings.Fields(update.Message.Text)
+	if len(parts) > 1 && strings.HasPrefix(parts[1], "@") {
+		author := strings.TrimPrefix(parts[1], "@")
+		quotes, err := loadQuotes("chat = ?", channel)
+		if err != nil {
+			log.Println("Can't load quotes for author")
+			log.Println(err)
+			return
+		}
+
+		var authorQuotes []Quote
+		for _, q := range quotes {
+			if quoteHasAuthor(q, author) {
+				authorQuotes = append(authorQuotes, q)
+			}
+		}
+		if len(authorQuotes) == 0 {
+			sendText(ctx, b, update, "Цитат от "+author+" не найдено")
+			return
+		}
+		sendQuote(ctx, b, update, authorQuotes[rand.Intn(len(authorQuotes))])
+		return
+	}
+	if len(parts) > 1 && strings.HasPrefix(parts[1], "#") {
+		tag := normalizeQuoteTag(parts[1])
+		quotes, err := loadQuotes("chat = ? AND quote_id IN (SELECT quote_id FROM quote_tags WHERE chat = ? AND tag = ?) ORDER BY RANDOM() LIMIT 1", channel, channel, tag)
+		if err != nil {
+			log.Println("Can't load random quote by tag")
+			log.Println(err)
+			return
+		}
+		if len(quotes) == 0 {
+			sendText(ctx, b, update, "Цитат с тегом #"+tag+" не найдено")
+			return
+		}
+		sendQuote(ctx, b, update, quotes[0])
+		return
+	}
 
-func handleFq(ctx context.Context, b *bot.Bot, update *models.Update) {
-	quotes := loadQuotes("#nnm")
+	quotes, err := loadQuotes("chat = ? ORDER BY RANDOM() LIMIT 1", channel)
+	if err != nil {
+		log.Println("Can't load random quote")
+		log.Println(err)
+		return
+	}
 
-	if len(update.Message.Text) <= 4 {
+	if len(quotes) == 0 {
 		b.SendMessage(ctx, &bot.SendMessageParams{
 			ChatID: update.Message.Chat.ID,
-			Text:   "Нужно указать текст для поиска",
+			Text:   "В этом чате пока нет цитат",
 		})
 		return
 	}
 
-	searchText := update.Message.Text[4:]
-
-	var foundQuotes []Quote
+	sendQuote(ctx, b, update, quotes[0])
+}
 
+// countQuoteAuthors counts quotes per author, every participant of a dialogue
+// quote is counted once.
+func countQuoteAuthors(quotes []Quote) []ReactionStat {
+	index := make(map[string]int)
+	var authorStats []ReactionStat
 	for _, q := range quotes {
-		if strings.Contains(q.quote, searchText) {
-			foundQuotes = append(foundQuotes, q)
+		for _, name := range strings.Split(q.author, ", ") {
+			key := normalizeSearchText(name)
+			if i, exists := index[key]; exists {
+				authorStats[i].count++
+				continue
+			}
+			index[key] = len(authorStats)
+			authorStats = append(authorStats, ReactionStat{name: name, count: 1})
 		}
+	}
+	sort.SliceStable(authorStats, func(i, j int) bool {
+		return authorStats[i].count > authorStats[j].count
+	})
+	return authorStats
+}
+
+func handleQAuthors(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote authors top")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
 
-		if len(foundQuotes) == 10 {
+	quotes, err := loadQuotes("chat = ?", getQuoteChannel(update.Message.Chat.ID))
+	if err != nil {
+		log.Println("Can't get quote authors top")
+		log.Println(err)
+		return
+	}
+
+	msg := "Кого цитируют чаще всех:\n"
+	place := 1
+	for _, item := range countQuoteAuthors(quotes) {
+		if place > 10 {
 			break
 		}
+		msg += fmt.Sprintf("%d. %s: %d\n", place, item.name, item.count)
+		place++
+	}
+	if place == 1 {
+		msg += "Цитат пока нет"
+	}
+
+	sendText(ctx, b, update, msg)
+}
+
+func handleBindQ(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote channel binding")
+	chatID := update.Message.Chat.ID
+	parts := strings.Fields(update.Message.Text)
+
+	if len(parts) < 2 {
+		sendText(ctx, b, update, "Цитаты этого чата хранятся в базе: "+getQuoteChannel(chatID))
+		return
+	}
+
+	if update.Message.Chat.Type == "private" {
+		sendText(ctx, b, update, "Привязывать базу цитат можно только в группах")
+		return
+	}
+
+	if update.Message.From == nil || !isChatAdmin(ctx, b, update.Message.Chat, update.Message.From.ID) {
+		sendText(ctx, b, update, "Привязывать базу цитат могут только администраторы чата")
+		return
+	}
+
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	channel := parts[1]
+	if !isValidQuoteChannel(channel) {
+		sendText(ctx, b, update, "Странное имя базы цитат: "+channel)
+		return
+	}
+	// Chat ID bases belong to their chats, only the old file bases are shared.
+	if _, err := strconv.ParseInt(channel, 10, 64); err == nil {
+		sendText(ctx, b, update, "Привязать можно только старую базу из файла, базы других чатов трогать нельзя")
+		return
+	}
+
+	owners, err := loadQuoteChannelChats(channel)
+	if err != nil {
+		log.Println("Can't load quote channel bindings")
+		log.Println(err)
+		return
+	}
+	for _, owner := range owners {
+		if owner != chatID && !isChatAdmin(ctx, b, models.Chat{ID: owner}, update.Message.From.ID) {
+			sendText(ctx, b, update, "База "+channel+" уже привязана к другому чату, привязать её может только администратор того чата")
+			return
+		}
+	}
+
+	count, err := countQuotes(channel)
+	if err != nil {
+		log.Println("Can't count quotes")
+		log.Println(err)
+		return
+	}
+	_, fileErr := os.Stat(getQuoteFilePath(channel))
+	if count == 0 && fileErr != nil {
+		sendText(ctx, b, update, "База цитат "+channel+" не найдена")
+		return
+	}
+
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Can't start quote binding transaction")
+		log.Println(err)
+		return
+	}
+
+	if count == 0 {
+		imported, err := importLegacyQuotes(tx, channel)
+		if err != nil {
+			_ = tx.Rollback()
+			log.Println("Can't import legacy quotes")
+			log.Println(err)
+			sendText(ctx, b, update, "Что-то пошло не так и база цитат не привязалась")
+			return
+		}
+		log.Printf("Imported %d legacy quotes for channel %s", imported, channel)
+
+		if err = fillQuoteSearchText(tx); err != nil {
+			_ = tx.Rollback()
+			log.Println("Can't index imported quotes")
+			log.Println(err)
+			sendText(ctx, b, update, "Что-то пошло не так и база цитат не привязалась")
+			return
+		}
+	}
+
+	if _, err = tx.Exec(`
+		INSERT INTO quote_chat_binding(chat_id, channel, updated_at)
+		VALUES (?, ?, ?)
+		ON CONFLICT(chat_id) DO UPDATE SET
+			channel = excluded.channel,
+			updated_at = excluded.updated_at
+	`, chatID, channel, time.Now().Unix()); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't save quote channel binding")
+		log.Println(err)
+		sendText(ctx, b, update, "Что-то пошло не так и база цитат не привязалась")
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Can't commit quote binding transaction")
+		log.Println(err)
+		sendText(ctx, b, update, "Что-то пошло не так и база цитат не привязалась")
+		return
+	}
+
+	sendText(ctx, b, update, "Чат привязан к базе цитат "+channel)
+}
+
+// loadQuoteChannelChats returns the chats bound to the quote base.
+func loadQuoteChannelChats(channel string) ([]int64, error) {
+	rows, err := statsDB.Query("SELECT chat_id FROM quote_chat_binding WHERE channel = ? ORDER BY chat_id", channel)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var chats []int64
+	for rows.Next() {
+		var chatID int64
+		if err = rows.Scan(&chatID); err != nil {
+			return nil, err
+		}
+		chats = append(chats, chatID)
+	}
+	return chats, rows.Err()
+}
+
+// isQuoteChannelAdmin checks the user against the chats owning the base: the
+// chat of a chat ID base and every chat bound to it. A private base belongs
+// to its user.
+func isQuoteChannelAdmin(ctx context.Context, b *bot.Bot, channel string, userID int64) bool {
+	owners, err := loadQuoteChannelChats(channel)
+	if err != nil {
+		log.Println("Can't load quote channel bindings")
+		log.Println(err)
+		return false
+	}
+	if chatID, err := strconv.ParseInt(channel, 10, 64); err == nil {
+		owners = append(owners, chatID)
+	}
+
+	for _, owner := range owners {
+		if owner == userID {
+			return true
+		}
+		// Without the type the chat is never taken for a private one.
+		if owner < 0 && isChatAdmin(ctx, b, models.Chat{ID: owner}, userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// canManageQuote allows changing a quote to the user who submitted it and to
+// administrators of the chats owning the base.
+func canManageQuote(ctx context.Context, b *bot.Bot, update *models.Update, channel string, q Quote) bool {
+	if update.Message.From == nil {
+		return false
+	}
+	if q.addedBy != 0 && q.addedBy == update.Message.From.ID {
+		return true
+	}
+	return isQuoteChannelAdmin(ctx, b, channel, update.Message.From.ID)
+}
+
+func saveQuoteHistory(tx *sql.Tx, channel string, q Quote, action string, changedBy int64) error {
+	_, err := tx.Exec(`
+		INSERT INTO quote_history(chat, quote_id, action, author, text, changed_by, changed_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, channel, q.id, action, q.author, q.quote, changedBy, time.Now().Unix())
+	return err
+}
+
+// parseQuoteIdArg returns the quote number passed as the first command
+// argument or a reply text describing the problem.
+func parseQuoteIdArg(parts []string) (int, string) {
+	if len(parts) < 2 {
+		return 0, "Нужно указать номер цитаты"
+	}
+	id, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, "Странный номер цитаты: " + parts[1]
+	}
+	return id, ""
+}
+
+func handleDq(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote delete")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	id, problem := parseQuoteIdArg(strings.Fields(update.Message.Text))
+	if problem != "" {
+		sendText(ctx, b, update, problem)
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	q, found, err := loadQuote(channel, id)
+	if err != nil {
+		log.Println("Can't load quote")
+		log.Println(err)
+		return
+	}
+	if !found {
+		sendText(ctx, b, update, fmt.Sprintf("Цитата с номером %d не найдена", id))
+		return
+	}
+	if !canManageQuote(ctx, b, update, channel, q) {
+		sendText(ctx, b, update, "Удалять цитату могут только её автор и администраторы чата")
+		return
+	}
+
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Can't start quote delete transaction")
+		log.Println(err)
+		return
+	}
+
+	if err = saveQuoteHistory(tx, channel, q, "delete", update.Message.From.ID); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't save quote history")
+		log.Println(err)
+		return
+	}
+
+	if _, err = tx.Exec("UPDATE quotes SET deleted_at = ?, deleted_by = ? WHERE chat = ? AND quote_id = ?", time.Now().Unix(), update.Message.From.ID, channel, id); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't delete quote")
+		log.Println(err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Can't commit quote delete transaction")
+		log.Println(err)
+		return
+	}
+
+	sendText(ctx, b, update, fmt.Sprintf("Цитата %d удалена, вернуть её можно командой !uq %d", id, id))
+}
+
+func handleEq(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote edit")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	text := strings.TrimSpace(update.Message.Text)
+	parts := strings.Fields(text)
+	id, problem := parseQuoteIdArg(parts)
+	if problem != "" {
+		sendText(ctx, b, update, problem)
+		return
+	}
+	// The new text keeps its line breaks, only the command and the ID are cut.
+	rest := strings.TrimSpace(strings.TrimPrefix(text, parts[0]))
+	newText := strings.TrimSpace(strings.TrimPrefix(rest, parts[1]))
+	if newText == "" {
+		sendText(ctx, b, update, "Нужно указать новый текст цитаты")
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	q, found, err := loadQuote(channel, id)
+	if err != nil {
+		log.Println("Can't load quote")
+		log.Println(err)
+		return
+	}
+	if !found {
+		sendText(ctx, b, update, fmt.Sprintf("Цитата с номером %d не найдена", id))
+		return
+	}
+	if !canManageQuote(ctx, b, update, channel, q) {
+		sendText(ctx, b, update, "Редактировать цитату могут только её автор и администраторы чата")
+		return
+	}
+
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Can't start quote edit transaction")
+		log.Println(err)
+		return
+	}
+
+	if err = saveQuoteHistory(tx, channel, q, "edit", update.Message.From.ID); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't save quote history")
+		log.Println(err)
+		return
+	}
+
+	if _, err = tx.Exec("UPDATE quotes SET text = ?, search_text = ? WHERE chat = ? AND quote_id = ?", newText, normalizeSearchText(newText), channel, id); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't edit quote")
+		log.Println(err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Can't commit quote edit transaction")
+		log.Println(err)
+		return
+	}
+
+	q.quote = newText
+	sendText(ctx, b, update, "Цитата обновлена:\n"+formatQuotes([]Quote{q}))
+}
+
+func handleUq(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote restore")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	id, problem := parseQuoteIdArg(strings.Fields(update.Message.Text))
+	if problem != "" {
+		sendText(ctx, b, update, problem)
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	var q Quote
+	var tags string
+	var deletedAt int64
+	err := statsDB.QueryRow("SELECT "+quoteColumns+", deleted_at FROM quotes WHERE chat = ? AND quote_id = ?", channel, id).Scan(&q.id, &q.createdAt, &q.addedBy, &q.author, &q.quote, &q.score, &tags, &deletedAt)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println("Can't load deleted quote")
+		log.Println(err)
+		return
+	}
+	if err == sql.ErrNoRows || deletedAt == 0 {
+		sendText(ctx, b, update, fmt.Sprintf("Удалённая цитата с номером %d не найдена", id))
+		return
+	}
+	q.date = formatQuoteDate(q.createdAt)
+	q.tags = strings.Fields(tags)
+	if !canManageQuote(ctx, b, update, channel, q) {
+		sendText(ctx, b, update, "Восстанавливать цитату могут только её автор и администраторы чата")
+		return
+	}
+
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		log.Println("Can't start quote restore transaction")
+		log.Println(err)
+		return
+	}
+
+	if err = saveQuoteHistory(tx, channel, q, "restore", update.Message.From.ID); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't save quote history")
+		log.Println(err)
+		return
+	}
+
+	if _, err = tx.Exec("UPDATE quotes SET deleted_at = 0, deleted_by = 0 WHERE chat = ? AND quote_id = ?", channel, id); err != nil {
+		_ = tx.Rollback()
+		log.Println("Can't restore quote")
+		log.Println(err)
+		return
+	}
+
+	if err = tx.Commit(); err != nil {
+		log.Println("Can't commit quote restore transaction")
+		log.Println(err)
+		return
 	}
-	msg := formatQuotes(foundQuotes)
-	b.SendMessage(ctx, &bot.SendMessageParams{
-		ChatID: update.Message.Chat.ID,
-		Text:   fmt.Sprintf("Результаты поиска [%d]:\n%s", len(foundQuotes), msg),
-	})
 
+	sendText(ctx, b, update, "Цитата восстановлена:\n"+formatQuotes([]Quote{q}))
 }
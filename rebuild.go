@@ -0,0 +1,327 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot/models"
+)
+
+const updateDumpMarker = "Update dump: "
+
+type replayCounts struct {
+	messages  int
+	edits     int
+	reactions int
+	skipped   int
+}
+
+// replayUpdateToStats feeds an update to the same stats handlers the bot
+// uses, without logging or answering anything.
+func replayUpdateToStats(ctx context.Context, update *models.Update, counts *replayCounts) {
+	switch {
+	case update.MessageReaction != nil:
+		handleReactionToStats(ctx, update.MessageReaction)
+		counts.reactions++
+	case update.MessageReactionCount != nil:
+		handleReactionCountToStats(ctx, update.MessageReactionCount)
+		counts.reactions++
+	case update.EditedMessage != nil:
+		handleEditToStats(ctx, update.EditedMessage)
+		counts.edits++
+	case update.Message != nil:
+		handleMsgToStats(ctx, nil, update)
+		counts.messages++
+	default:
+		counts.skipped++
+	}
+}
+
+// forwardOriginFromLog restores a forward origin that gives the same target
+// key and label as the original one.
+func forwardOriginFromLog(key string, label string) *models.MessageOrigin {
+	kind, value, _ := strings.Cut(key, ":")
+	id, _ := strconv.ParseInt(value, 10, 64)
+	switch kind {
+	case "user":
+		return &models.MessageOrigin{
+			Type:              models.MessageOriginTypeUser,
+			MessageOriginUser: &models.MessageOriginUser{SenderUser: models.User{ID: id, FirstName: label}},
+		}
+	case "hidden_user":
+		return &models.MessageOrigin{
+			Type:                    models.MessageOriginTypeHiddenUser,
+			MessageOriginHiddenUser: &models.MessageOriginHiddenUser{SenderUserName: value},
+		}
+	case "chat":
+		return &models.MessageOrigin{
+			Type:              models.MessageOriginTypeChat,
+			MessageOriginChat: &models.MessageOriginChat{SenderChat: models.Chat{ID: id, Title: label}},
+		}
+	case "channel":
+		return &models.MessageOrigin{
+			Type:                 models.MessageOriginTypeChannel,
+			MessageOriginChannel: &models.MessageOriginChannel{Chat: models.Chat{ID: id, Title: label}},
+		}
+	}
+	// A forward without a known sender still counts as a forward.
+	return &models.MessageOrigin{}
+}
+
+// updateFromLogRecord turns a JSONL chat log record back into the update the
+// bot received, keeping the fields the stats are counted from.
+func updateFromLogRecord(record chatLogRecord) (*models.Update, error) {
+	date, err := time.Parse(time.RFC3339, record.Time)
+	if err != nil {
+		return nil, err
+	}
+
+	msg := &models.Message{
+		ID:              record.MessageID,
+		MessageThreadID: record.ThreadID,
+		Date:            int(date.Unix()),
+		Chat:            models.Chat{ID: record.ChatID, Type: models.ChatType(record.ChatType), Title: record.ChatTitle},
+		Text:            record.Text,
+		Caption:         record.Caption,
+	}
+	if record.UserID != 0 {
+		msg.From = &models.User{ID: record.UserID, Username: record.Username}
+		if record.Username == "" {
+			msg.From.FirstName = record.Name
+		}
+	} else if record.SenderChatID != 0 {
+		msg.SenderChat = &models.Chat{ID: record.SenderChatID, Title: record.Name}
+	}
+	if record.ForwardFromKey != "" || record.ForwardDate != "" {
+		msg.ForwardOrigin = forwardOriginFromLog(record.ForwardFromKey, record.ForwardFrom)
+	}
+
+	if record.EditTime == "" {
+		return &models.Update{Message: msg}, nil
+	}
+	editDate, err := time.Parse(time.RFC3339, record.EditTime)
+	if err != nil {
+		return nil, err
+	}
+	msg.EditDate = int(editDate.Unix())
+	return &models.Update{EditedMessage: msg}, nil
+}
+
+type jsonLogFile struct {
+	path string
+	day  string
+}
+
+// findJSONLogs returns the JSONL chat logs under the paths, oldest day first,
+// so every chat is replayed in the order it was written.
+func findJSONLogs(paths []string) ([]jsonLogFile, error) {
+	var files []jsonLogFile
+	for _, root := range paths {
+		err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+			if err != nil || entry.IsDir() {
+				return err
+			}
+			if entry.Name() != "logs.jsonl" && entry.Name() != "logs.jsonl.gz" {
+				return nil
+			}
+			dayDir := filepath.Dir(path)
+			monthDir := filepath.Dir(dayDir)
+			day, errDay := strconv.Atoi(filepath.Base(dayDir))
+			month, errMonth := strconv.Atoi(filepath.Base(monthDir))
+			year, errYear := strconv.Atoi(filepath.Base(filepath.Dir(monthDir)))
+			if errDay != nil || errMonth != nil || errYear != nil {
+				return fmt.Errorf("%s is not inside a <year>/<month>/<day> directory", path)
+			}
+			files = append(files, jsonLogFile{path: strings.TrimSuffix(path, ".gz"), day: fmt.Sprintf("%04d-%02d-%02d", year, month, day)})
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	sort.SliceStable(files, func(i, j int) bool {
+		if files[i].day != files[j].day {
+			return files[i].day < files[j].day
+		}
+		return files[i].path < files[j].path
+	})
+	return files, nil
+}
+
+func replayJSONLogs(ctx context.Context, paths []string, counts *replayCounts) error {
+	files, err := findJSONLogs(paths)
+	if err != nil {
+		return err
+	}
+	// Text logs keep names but no user IDs, the stats can't be counted from them.
+	if len(files) == 0 {
+		return fmt.Errorf("no JSONL chat logs in %s, only logs written with LOG_FORMAT=jsonl or both can be replayed", strings.Join(paths, ", "))
+	}
+
+	seen := make(map[string]bool)
+	for _, file := range files {
+		data, err := readLogFile(file.path)
+		if err != nil {
+			return err
+		}
+		for n, line := range strings.Split(string(data), "\n") {
+			if line == "" {
+				continue
+			}
+			var record chatLogRecord
+			if err = json.Unmarshal([]byte(line), &record); err != nil {
+				return fmt.Errorf("%s:%d: %w", file.path, n+1, err)
+			}
+			// Old title directories merged into a chat may repeat lines.
+			key := fmt.Sprintf("%d:%d:%s", record.ChatID, record.MessageID, record.EditTime)
+			if seen[key] {
+				counts.skipped++
+				continue
+			}
+			seen[key] = true
+
+			update, err := updateFromLogRecord(record)
+			if err != nil {
+				return fmt.Errorf("%s:%d: %w", file.path, n+1, err)
+			}
+			replayUpdateToStats(ctx, update, counts)
+		}
+	}
+	return nil
+}
+
+// replayUpdateDumps reads updates one per line, either raw JSON or the
+// "Update dump:" lines of the bot output, gzipped files are fine too.
+func replayUpdateDumps(ctx context.Context, paths []string, counts *replayCounts) error {
+	seen := make(map[int64]bool)
+	for _, path := range paths {
+		if err := replayUpdateDumpFile(ctx, path, seen, counts); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func replayUpdateDumpFile(ctx context.Context, path string, seen map[int64]bool, counts *replayCounts) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 16*1024*1024)
+	n := 0
+	for scanner.Scan() {
+		n++
+		line := scanner.Text()
+		if _, dump, found := strings.Cut(line, updateDumpMarker); found {
+			line = dump
+		} else if !strings.HasPrefix(line, "{") {
+			continue
+		}
+
+		var update models.Update
+		if err = json.Unmarshal([]byte(line), &update); err != nil {
+			return fmt.Errorf("%s:%d: %w", path, n, err)
+		}
+		// Updates come again after a restart if they weren't confirmed.
+		if update.ID != 0 && seen[update.ID] {
+			counts.skipped++
+			continue
+		}
+		seen[update.ID] = true
+		replayUpdateToStats(ctx, &update, counts)
+	}
+	return scanner.Err()
+}
+
+func runRebuildStats(args []string) int {
+	flags := flag.NewFlagSet("rebuild-stats", flag.ExitOnError)
+	dbPath := flags.String("db", "stats.rebuilt.db", "new database to fill, must not exist")
+	from := flags.String("from", "logs", "source: logs for JSONL chat logs, updates for update dumps")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "Usage: goBotter rebuild-stats [flags] [paths]")
+		fmt.Fprintln(os.Stderr, "Replays history into a fresh database, paths are log directories for -from logs")
+		fmt.Fprintln(os.Stderr, "(the logs directory by default) or update dump files for -from updates.")
+		fmt.Fprintln(os.Stderr, "Only JSONL chat logs are replayed, text logs have no user IDs. Chat logs have")
+		fmt.Fprintln(os.Stderr, "no reactions, replay update dumps to count them.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *from != "logs" && *from != "updates" {
+		log.Println("Unknown source", *from)
+		return 2
+	}
+	paths := flags.Args()
+	if len(paths) == 0 {
+		if *from == "updates" {
+			log.Println("Pass the update dump files")
+			return 2
+		}
+		root, err := getLogsRoot()
+		if err != nil {
+			log.Println("Can't get logs directory")
+			log.Println(err)
+			return 1
+		}
+		paths = []string{root}
+	}
+
+	if _, err := os.Stat(*dbPath); err == nil {
+		log.Printf("%s already exists, stats are rebuilt into a fresh database", *dbPath)
+		return 2
+	}
+	if err := openStatsStorage(*dbPath); err != nil {
+		log.Println("Can't initialize stats storage")
+		log.Println(err)
+		return 1
+	}
+	defer statsDB.Close()
+
+	var counts replayCounts
+	var err error
+	if *from == "logs" {
+		err = replayJSONLogs(context.Background(), paths, &counts)
+	} else {
+		err = replayUpdateDumps(context.Background(), paths, &counts)
+	}
+	if err != nil {
+		log.Println("Can't replay history")
+		log.Println(err)
+		// The half filled database would only block the next run.
+		statsDB.Close()
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(*dbPath + suffix)
+		}
+		return 1
+	}
+
+	log.Printf("Rebuilt %s: messages %d, edits %d, reactions %d, skipped %d", *dbPath, counts.messages, counts.edits, counts.reactions, counts.skipped)
+	return 0
+}
@@ -0,0 +1,417 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const (
+	quoteSearchPrefix   = "fq:"
+	quoteSearchPageSize = 10
+	quoteSearchTTL      = 7 * 24 * time.Hour
+)
+
+type quoteSearchQuery struct {
+	terms    []string
+	phrases  []string
+	excludes []string
+	tags     []string
+	author   string
+	since    int64
+	until    int64
+	invalid  string
+}
+
+// normalizeSearchText lowercases text for search, SQLite LOWER and LIKE only
+// fold ASCII so Cyrillic is folded here.
+func normalizeSearchText(text string) string {
+	return strings.ReplaceAll(strings.ToLower(text), "ё", "е")
+}
+
+func fillQuoteSearchText(tx *sql.Tx) error {
+	type pending struct {
+		chat string
+		id   int
+		text string
+	}
+
+	rows, err := tx.Query("SELECT chat, quote_id, text FROM quotes WHERE search_text = '' AND text != ''")
+	if err != nil {
+		return err
+	}
+	var items []pending
+	for rows.Next() {
+		var item pending
+		if err = rows.Scan(&item.chat, &item.id, &item.text); err != nil {
+			rows.Close()
+			return err
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		rows.Close()
+		return err
+	}
+	rows.Close()
+
+	for _, item := range items {
+		if _, err = tx.Exec("UPDATE quotes SET search_text = ? WHERE chat = ? AND quote_id = ?", normalizeSearchText(item.text), item.chat, item.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func parseSearchDay(value string) (time.Time, bool) {
+	day, err := time.ParseInLocation(dayLayout, value, time.Local)
+	return day, err == nil
+}
+
+// parseSearchQuery splits the search text into words, "quoted phrases",
+// -excluded words, #tags and author:, since:, until: filters.
+func parseSearchQuery(text string) quoteSearchQuery {
+	var query quoteSearchQuery
+	text = normalizeSearchText(text)
+
+	for len(text) > 0 {
+		text = strings.TrimLeftFunc(text, unicode.IsSpace)
+		if text == "" {
+			break
+		}
+
+		exclude := false
+		if strings.HasPrefix(text, "-") {
+			exclude = true
+			text = text[1:]
+		}
+
+		var token string
+		phrase := false
+		if strings.HasPrefix(text, "\"") {
+			end := strings.Index(text[1:], "\"")
+			if end < 0 {
+				token = text[1:]
+				text = ""
+			} else {
+				token = text[1 : end+1]
+				text = text[end+2:]
+			}
+			token = strings.Join(strings.Fields(token), " ")
+			phrase = true
+		} else {
+			end := strings.IndexFunc(text, unicode.IsSpace)
+			if end < 0 {
+				end = len(text)
+			}
+			token = text[:end]
+			text = text[end:]
+		}
+
+		if token == "" {
+			continue
+		}
+		if !exclude && !phrase {
+			if strings.HasPrefix(token, "#") && normalizeQuoteTag(token) != "" {
+				query.tags = append(query.tags, normalizeQuoteTag(token))
+				continue
+			}
+			if value, ok := strings.CutPrefix(token, "author:"); ok {
+				query.author = strings.TrimPrefix(value, "@")
+				continue
+			}
+			if value, ok := strings.CutPrefix(token, "since:"); ok {
+				day, valid := parseSearchDay(value)
+				if !valid {
+					query.invalid = token
+					continue
+				}
+				query.since = day.Unix()
+				continue
+			}
+			if value, ok := strings.CutPrefix(token, "until:"); ok {
+				day, valid := parseSearchDay(value)
+				if !valid {
+					query.invalid = token
+					continue
+				}
+				query.until = day.AddDate(0, 0, 1).Unix()
+				continue
+			}
+		}
+		switch {
+		case exclude:
+			query.excludes = append(query.excludes, token)
+		case phrase:
+			query.phrases = append(query.phrases, token)
+		default:
+			query.terms = append(query.terms, token)
+		}
+	}
+	return query
+}
+
+func (q quoteSearchQuery) isEmpty() bool {
+	return len(q.terms) == 0 && len(q.phrases) == 0 && len(q.tags) == 0 && q.author == "" && q.since == 0 && q.until == 0
+}
+
+func escapeLike(text string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(text)
+}
+
+func isWordSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+}
+
+func quoteSearchRank(text string, query quoteSearchQuery) int {
+	rank := 0
+	words := strings.FieldsFunc(text, isWordSeparator)
+	for _, term := range query.terms {
+		rank += strings.Count(text, term)
+		for _, word := range words {
+			if word == term {
+				rank += 2
+			}
+		}
+	}
+	for _, phrase := range query.phrases {
+		rank += 3 * strings.Count(text, phrase)
+	}
+	return rank
+}
+
+// searchQuotes returns all quotes matching the query, the most relevant first.
+func searchQuotes(channel string, query quoteSearchQuery) ([]Quote, error) {
+	where := "chat = ?"
+	args := []any{channel}
+	for _, term := range append(append([]string{}, query.terms...), query.phrases...) {
+		where += ` AND search_text LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+	for _, term := range query.excludes {
+		where += ` AND search_text NOT LIKE ? ESCAPE '\'`
+		args = append(args, "%"+escapeLike(term)+"%")
+	}
+	for _, tag := range query.tags {
+		where += " AND quote_id IN (SELECT quote_id FROM quote_tags WHERE chat = ? AND tag = ?)"
+		args = append(args, channel, tag)
+	}
+	if query.since != 0 {
+		where += " AND created_at >= ?"
+		args = append(args, query.since)
+	}
+	if query.until != 0 {
+		where += " AND created_at < ?"
+		args = append(args, query.until)
+	}
+
+	found, err := loadQuotes(where+" ORDER BY quote_id", args...)
+	if err != nil {
+		return nil, err
+	}
+
+	quotes := found[:0]
+	for _, q := range found {
+		if query.author == "" || quoteHasAuthor(q, query.author) {
+			quotes = append(quotes, q)
+		}
+	}
+
+	ranks := make(map[int]int, len(quotes))
+	for _, q := range quotes {
+		ranks[q.id] = quoteSearchRank(normalizeSearchText(q.quote), query)
+	}
+	sort.SliceStable(quotes, func(i, j int) bool {
+		if ranks[quotes[i].id] != ranks[quotes[j].id] {
+			return ranks[quotes[i].id] > ranks[quotes[j].id]
+		}
+		if quotes[i].score != quotes[j].score {
+			return quotes[i].score > quotes[j].score
+		}
+		return quotes[i].id > quotes[j].id
+	})
+	return quotes, nil
+}
+
+func saveQuoteSearch(channel string, text string) (int64, error) {
+	now := time.Now()
+	if _, err := statsDB.Exec("DELETE FROM quote_searches WHERE created_at < ?", now.Add(-quoteSearchTTL).Unix()); err != nil {
+		return 0, err
+	}
+	res, err := statsDB.Exec("INSERT INTO quote_searches(chat, query, created_at) VALUES (?, ?, ?)", channel, text, now.Unix())
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+func loadQuoteSearch(id int64) (string, string, bool, error) {
+	var channel, text string
+	err := statsDB.QueryRow("SELECT chat, query FROM quote_searches WHERE id = ?", id).Scan(&channel, &text)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", "", false, nil
+		}
+		return "", "", false, err
+	}
+	return channel, text, true, nil
+}
+
+// formatQuoteSearchPage renders one page of results and the paging buttons
+// pointing to the saved search.
+func formatQuoteSearchPage(quotes []Quote, searchID int64, page int) (string, *models.InlineKeyboardMarkup) {
+	pages := (len(quotes) + quoteSearchPageSize - 1) / quoteSearchPageSize
+	if page >= pages {
+		page = pages - 1
+	}
+	if page < 0 {
+		page = 0
+	}
+
+	start := page * quoteSearchPageSize
+	end := start + quoteSearchPageSize
+	if end > len(quotes) {
+		end = len(quotes)
+	}
+
+	msg := fmt.Sprintf("Результаты поиска [%d]:\n%s", len(quotes), formatQuotes(quotes[start:end]))
+	if pages <= 1 {
+		return msg, nil
+	}
+	msg += fmt.Sprintf("Страница %d из %d", page+1, pages)
+
+	var buttons []models.InlineKeyboardButton
+	if page > 0 {
+		buttons = append(buttons, models.InlineKeyboardButton{Text: "◀ Назад", CallbackData: fmt.Sprintf("%s%d:%d", quoteSearchPrefix, searchID, page-1)})
+	}
+	if page < pages-1 {
+		buttons = append(buttons, models.InlineKeyboardButton{Text: "Дальше ▶", CallbackData: fmt.Sprintf("%s%d:%d", quoteSearchPrefix, searchID, page+1)})
+	}
+	return msg, &models.InlineKeyboardMarkup{InlineKeyboard: [][]models.InlineKeyboardButton{buttons}}
+}
+
+func handleFq(ctx context.Context, b *bot.Bot, update *models.Update) {
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	searchText := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "!fq"))
+	query := parseSearchQuery(searchText)
+	if query.invalid != "" {
+		sendText(ctx, b, update, "Странный фильтр "+query.invalid+", дату нужно указывать как 2015-01-01")
+		return
+	}
+	if query.isEmpty() {
+		sendText(ctx, b, update, "Нужно указать текст для поиска, #тег, author:<имя>, since:<дата> или until:<дата>")
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	foundQuotes, err := searchQuotes(channel, query)
+	if err != nil {
+		log.Println("Can't search quotes")
+		log.Println(err)
+		return
+	}
+
+	var searchID int64
+	if len(foundQuotes) > quoteSearchPageSize {
+		searchID, err = saveQuoteSearch(channel, searchText)
+		if err != nil {
+			log.Println("Can't save quote search")
+			log.Println(err)
+			return
+		}
+	}
+
+	msg, keyboard := formatQuoteSearchPage(foundQuotes, searchID, 0)
+	params := &bot.SendMessageParams{
+		ChatID: update.Message.Chat.ID,
+		Text:   msg,
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+	if update.Message.MessageThreadID != 0 {
+		params.MessageThreadID = update.Message.MessageThreadID
+	}
+	if _, err = b.SendMessage(ctx, params); err != nil {
+		log.Println("Can't send quote search results")
+		log.Println(err)
+	}
+}
+
+func handleQuoteSearchPage(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote search page")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	msg := update.CallbackQuery.Message.Message
+	if msg == nil {
+		answerCallback(ctx, b, update, "Сообщение с поиском слишком старое")
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(update.CallbackQuery.Data, quoteSearchPrefix), ":")
+	if len(parts) != 2 {
+		answerCallback(ctx, b, update, "Странная страница")
+		return
+	}
+	searchID, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		answerCallback(ctx, b, update, "Странная страница")
+		return
+	}
+	page, err := strconv.Atoi(parts[1])
+	if err != nil {
+		answerCallback(ctx, b, update, "Странная страница")
+		return
+	}
+
+	channel, searchText, found, err := loadQuoteSearch(searchID)
+	if err != nil {
+		log.Println("Can't load quote search")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if !found || channel != getQuoteChannel(msg.Chat.ID) {
+		answerCallback(ctx, b, update, "Поиск устарел, повтори !fq")
+		return
+	}
+
+	foundQuotes, err := searchQuotes(channel, parseSearchQuery(searchText))
+	if err != nil {
+		log.Println("Can't search quotes")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+
+	text, keyboard := formatQuoteSearchPage(foundQuotes, searchID, page)
+	params := &bot.EditMessageTextParams{
+		ChatID:    msg.Chat.ID,
+		MessageID: msg.ID,
+		Text:      text,
+	}
+	if keyboard != nil {
+		params.ReplyMarkup = keyboard
+	}
+	if _, err = b.EditMessageText(ctx, params); err != nil {
+		log.Println("Can't update quote search page")
+		log.Println(err)
+	}
+	answerCallback(ctx, b, update, "")
+}
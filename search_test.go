@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	day := func(value string) int64 {
+		parsed, _ := time.ParseInLocation(dayLayout, value, time.Local)
+		return parsed.Unix()
+	}
+	tests := []struct {
+		text string
+		want quoteSearchQuery
+	}{
+		{"", quoteSearchQuery{}},
+		{"  Привет   Мир ", quoteSearchQuery{terms: []string{"привет", "мир"}}},
+		{"ёжик", quoteSearchQuery{terms: []string{"ежик"}}},
+		{`"Добрый  вечер" кот`, quoteSearchQuery{phrases: []string{"добрый вечер"}, terms: []string{"кот"}}},
+		{`"без конца`, quoteSearchQuery{phrases: []string{"без конца"}}},
+		{`кот -собака -"злой пёс"`, quoteSearchQuery{terms: []string{"кот"}, excludes: []string{"собака", "злой пес"}}},
+		{"- кот", quoteSearchQuery{terms: []string{"кот"}}},
+		{"#Работа #не-тег", quoteSearchQuery{tags: []string{"работа"}, terms: []string{"#не-тег"}}},
+		{"author:@Vasya", quoteSearchQuery{author: "vasya"}},
+		{"since:2024-01-01 until:2024-01-31", quoteSearchQuery{since: day("2024-01-01"), until: day("2024-02-01")}},
+		{"since:2024-02-30", quoteSearchQuery{invalid: "since:2024-02-30"}},
+		{"until:вчера", quoteSearchQuery{invalid: "until:вчера"}},
+		{`-author:vasya "author:vasya"`, quoteSearchQuery{excludes: []string{"author:vasya"}, phrases: []string{"author:vasya"}}},
+	}
+	for _, test := range tests {
+		if got := parseSearchQuery(test.text); !reflect.DeepEqual(got, test.want) {
+			t.Errorf("parseSearchQuery(%q) = %+v, want %+v", test.text, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const seenMaxText = 200
+
+type seenInfo struct {
+	lastMessage  int64
+	lastReaction int64
+	lastText     string
+	lastTextAt   int64
+}
+
+func isSeenOptedOut(userID int64) (bool, error) {
+	var count int
+	err := statsDB.QueryRow("SELECT COUNT(1) FROM seen_opt_out WHERE user_id = ?", userID).Scan(&count)
+	return count > 0, err
+}
+
+// findChatUser looks the user up by the name the stats know, usernames are
+// compared without case like Telegram does.
+func findChatUser(chatID int64, name string) (int64, string, bool, error) {
+	var userID int64
+	var username string
+	err := statsDB.QueryRow(`
+		SELECT user_id, username FROM (
+			SELECT user_id, username, updated_at FROM stats_total WHERE chat_id = ? AND username = ? COLLATE NOCASE
+			UNION ALL
+			SELECT user_id, username, updated_at FROM reaction_given_total WHERE chat_id = ? AND username = ? COLLATE NOCASE
+		)
+		ORDER BY updated_at DESC
+		LIMIT 1
+	`, chatID, name, chatID, name).Scan(&userID, &username)
+	if err == sql.ErrNoRows {
+		return 0, "", false, nil
+	}
+	if err != nil {
+		return 0, "", false, err
+	}
+	return userID, username, true, nil
+}
+
+func loadSeenInfo(chatID int64, userID int64) (seenInfo, error) {
+	var info seenInfo
+
+	// Messages without words don't touch stats_total, the author state has
+	// them all but only since it was added, so the latest of both wins.
+	err := statsDB.QueryRow(`
+		SELECT MAX(
+			COALESCE((SELECT MAX(updated_at) FROM message_author_state WHERE chat_id = ? AND author_user_id = ?), 0),
+			COALESCE((SELECT updated_at FROM stats_total WHERE chat_id = ? AND user_id = ?), 0)
+		)
+	`, chatID, userID, chatID, userID).Scan(&info.lastMessage)
+	if err != nil {
+		return info, err
+	}
+
+	err = statsDB.QueryRow("SELECT updated_at FROM reaction_given_total WHERE chat_id = ? AND user_id = ?", chatID, userID).Scan(&info.lastReaction)
+	if err != nil && err != sql.ErrNoRows {
+		return info, err
+	}
+
+	err = statsDB.QueryRow(`
+		SELECT t.text, t.message_date
+		FROM message_author_state a
+		JOIN message_text_state t ON t.chat_id = a.chat_id AND t.message_id = a.message_id
+		WHERE a.chat_id = ? AND a.author_user_id = ?
+		ORDER BY a.updated_at DESC, a.message_id DESC
+		LIMIT 1
+	`, chatID, userID).Scan(&info.lastText, &info.lastTextAt)
+	if err != nil && err != sql.ErrNoRows {
+		return info, err
+	}
+	return info, nil
+}
+
+func formatSeenAgo(unixTime int64, now time.Time) string {
+	ago := now.Sub(time.Unix(unixTime, 0))
+	switch {
+	case ago < time.Minute:
+		return "только что"
+	case ago < time.Hour:
+		return fmt.Sprintf("%d мин. назад", int(ago/time.Minute))
+	case ago < 24*time.Hour:
+		return fmt.Sprintf("%d ч. назад", int(ago/time.Hour))
+	default:
+		return fmt.Sprintf("%d дн. назад", int(ago/(24*time.Hour)))
+	}
+}
+
+func formatSeenTime(unixTime int64, now time.Time) string {
+	if unixTime == 0 {
+		return "не видел"
+	}
+	return fmt.Sprintf("%s (%s)", formatEditTime(unixTime), formatSeenAgo(unixTime, now))
+}
+
+func handleSeen(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle seen")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	chatID := update.Message.Chat.ID
+	arg := strings.TrimSpace(strings.TrimPrefix(update.Message.Text, "!seen"))
+
+	if arg == "off" || arg == "on" {
+		if update.Message.From == nil {
+			return
+		}
+		var err error
+		if arg == "off" {
+			_, err = statsDB.Exec("INSERT OR REPLACE INTO seen_opt_out(user_id, updated_at) VALUES (?, ?)", update.Message.From.ID, time.Now().Unix())
+		} else {
+			_, err = statsDB.Exec("DELETE FROM seen_opt_out WHERE user_id = ?", update.Message.From.ID)
+		}
+		if err != nil {
+			log.Println("Can't save seen opt-out")
+			log.Println(err)
+			return
+		}
+		if arg == "off" {
+			sendText(ctx, b, update, "Больше никому не покажу твою активность. Вернуть: !seen on")
+		} else {
+			sendText(ctx, b, update, "Снова показываю твою активность")
+		}
+		return
+	}
+
+	var userID int64
+	var name string
+	switch {
+	case arg != "":
+		var found bool
+		var err error
+		userID, name, found, err = findChatUser(chatID, strings.TrimPrefix(arg, "@"))
+		if err != nil {
+			log.Println("Can't find user for seen")
+			log.Println(err)
+			return
+		}
+		if !found {
+			sendText(ctx, b, update, "Не видел в этом чате "+arg)
+			return
+		}
+	case update.Message.ReplyToMessage != nil:
+		var found bool
+		userID, name, found = getMessageAuthor(update.Message.ReplyToMessage)
+		if !found {
+			sendText(ctx, b, update, "Не понял, чьё это сообщение")
+			return
+		}
+	default:
+		sendText(ctx, b, update, "Нужно указать @ник или ответить командой на сообщение. Скрыть себя: !seen off")
+		return
+	}
+
+	optedOut, err := isSeenOptedOut(userID)
+	if err != nil {
+		log.Println("Can't get seen opt-out")
+		log.Println(err)
+		return
+	}
+	if optedOut {
+		sendText(ctx, b, update, name+" скрывает свою активность")
+		return
+	}
+
+	info, err := loadSeenInfo(chatID, userID)
+	if err != nil {
+		log.Println("Can't load seen info")
+		log.Println(err)
+		return
+	}
+	if info.lastMessage == 0 && info.lastReaction == 0 {
+		sendText(ctx, b, update, "Не видел активности "+name+" в этом чате")
+		return
+	}
+
+	now := time.Now()
+	msg := fmt.Sprintf("%s\nПоследнее сообщение: %s\nПоследняя реакция: %s", name, formatSeenTime(info.lastMessage, now), formatSeenTime(info.lastReaction, now))
+	if info.lastText != "" {
+		msg += fmt.Sprintf("\nПоследний текст (%s): %s", formatEditTime(info.lastTextAt), shortenText(info.lastText, seenMaxText))
+	}
+	sendText(ctx, b, update, msg)
+}
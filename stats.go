@@ -68,8 +68,24 @@ func sendText(ctx context.Context, b *bot.Bot, update *models.Update, text strin
 	}
 }
 
+// initStatsStorage opens the bot database, the legacy quote files are
+// imported into it on the first start.
 func initStatsStorage() error {
-	db, err := sql.Open("sqlite3", "file:stats.db?_busy_timeout=5000&_journal_mode=WAL")
+	if err := openStatsStorage("stats.db"); err != nil {
+		return err
+	}
+	if err := importLegacyQuoteFiles(statsDB); err != nil {
+		statsDB.Close()
+		statsDB = nil
+		return err
+	}
+	return nil
+}
+
+// openStatsStorage opens the stats database at the path, creating the tables
+// and running the schema migrations when needed.
+func openStatsStorage(path string) error {
+	db, err := sql.Open("sqlite3", "file:"+path+"?_busy_timeout=5000&_journal_mode=WAL")
 	if err != nil {
 		return fmt.Errorf("can't open stat database: %w", err)
 	}
@@ -257,6 +273,149 @@ func initStatsStorage() error {
 		);
 
 		CREATE INDEX IF NOT EXISTS idx_message_author_state_chat_msg ON message_author_state(chat_id, message_id);
+		CREATE INDEX IF NOT EXISTS idx_message_author_state_author ON message_author_state(chat_id, author_user_id, updated_at);
+
+		CREATE TABLE IF NOT EXISTS message_text_state (
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			message_date INTEGER NOT NULL,
+			PRIMARY KEY(chat_id, message_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS message_edits (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			message_id INTEGER NOT NULL,
+			text TEXT NOT NULL,
+			edited_at INTEGER NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(chat_id, message_id);
+
+		CREATE TABLE IF NOT EXISTS quote_chat_binding (
+			chat_id INTEGER PRIMARY KEY,
+			channel TEXT NOT NULL,
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS quotes (
+			chat TEXT NOT NULL,
+			quote_id INTEGER NOT NULL,
+			created_at INTEGER NOT NULL,
+			added_by INTEGER NOT NULL DEFAULT 0,
+			author TEXT NOT NULL,
+			text TEXT NOT NULL,
+			PRIMARY KEY(chat, quote_id)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_quotes_chat_author ON quotes(chat, author);
+
+		CREATE TABLE IF NOT EXISTS quote_history (
+			chat TEXT NOT NULL,
+			quote_id INTEGER NOT NULL,
+			action TEXT NOT NULL,
+			author TEXT NOT NULL,
+			text TEXT NOT NULL,
+			changed_by INTEGER NOT NULL,
+			changed_at INTEGER NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_quote_history_chat_quote ON quote_history(chat, quote_id);
+
+		CREATE TABLE IF NOT EXISTS quote_votes (
+			chat TEXT NOT NULL,
+			quote_id INTEGER NOT NULL,
+			user_id INTEGER NOT NULL,
+			vote INTEGER NOT NULL,
+			updated_at INTEGER NOT NULL,
+			PRIMARY KEY(chat, quote_id, user_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS quote_tags (
+			chat TEXT NOT NULL,
+			quote_id INTEGER NOT NULL,
+			tag TEXT NOT NULL,
+			PRIMARY KEY(chat, quote_id, tag)
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_quote_tags_chat_tag ON quote_tags(chat, tag);
+
+		CREATE TABLE IF NOT EXISTS quote_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat TEXT NOT NULL,
+			query TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS quote_drafts (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat TEXT NOT NULL,
+			quote_date INTEGER NOT NULL,
+			added_by INTEGER NOT NULL,
+			author TEXT NOT NULL,
+			text TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '',
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS quote_moderation (
+			chat_id INTEGER PRIMARY KEY,
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS quote_submissions (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			chat TEXT NOT NULL,
+			quote_date INTEGER NOT NULL,
+			added_by INTEGER NOT NULL,
+			author TEXT NOT NULL,
+			text TEXT NOT NULL,
+			tags TEXT NOT NULL DEFAULT '',
+			status TEXT NOT NULL DEFAULT 'pending',
+			quote_id INTEGER NOT NULL DEFAULT 0,
+			reviewed_by INTEGER NOT NULL DEFAULT 0,
+			reviewed_at INTEGER NOT NULL DEFAULT 0,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE INDEX IF NOT EXISTS idx_quote_submissions_chat_status ON quote_submissions(chat_id, status);
+
+		CREATE TABLE IF NOT EXISTS quote_of_day (
+			chat_id INTEGER PRIMARY KEY,
+			post_time TEXT NOT NULL,
+			last_posted_day TEXT NOT NULL DEFAULT '',
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS quote_of_day_posted (
+			chat_id INTEGER NOT NULL,
+			chat TEXT NOT NULL,
+			quote_id INTEGER NOT NULL,
+			posted_at INTEGER NOT NULL,
+			PRIMARY KEY(chat_id, chat, quote_id)
+		);
+
+		CREATE TABLE IF NOT EXISTS log_searches (
+			id INTEGER PRIMARY KEY AUTOINCREMENT,
+			chat_id INTEGER NOT NULL,
+			query TEXT NOT NULL,
+			created_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS chat_log_settings (
+			chat_id INTEGER PRIMARY KEY,
+			disabled INTEGER NOT NULL DEFAULT 0,
+			retention_days INTEGER NOT NULL DEFAULT 0,
+			redact TEXT NOT NULL DEFAULT '',
+			updated_at INTEGER NOT NULL
+		);
+
+		CREATE TABLE IF NOT EXISTS seen_opt_out (
+			user_id INTEGER PRIMARY KEY,
+			updated_at INTEGER NOT NULL
+		);
 	`); err != nil {
 		db.Close()
 		return fmt.Errorf("can't create stat tables: %w", err)
@@ -267,6 +426,11 @@ func initStatsStorage() error {
 		return err
 	}
 
+	if err = migrateQuoteSchema(db); err != nil {
+		db.Close()
+		return err
+	}
+
 	statsDB = db
 	return nil
 }
@@ -334,6 +498,40 @@ func migrateLegacyStats(db *sql.DB) error {
 	return nil
 }
 
+// applyMigration runs apply in a transaction once and records it in
+// schema_migrations under the given name.
+func applyMigration(db *sql.DB, name string, apply func(tx *sql.Tx) error) error {
+	var applied int
+	err := db.QueryRow("SELECT COUNT(1) FROM schema_migrations WHERE name = ?", name).Scan(&applied)
+	if err != nil {
+		return fmt.Errorf("can't check migrations: %w", err)
+	}
+
+	if applied > 0 {
+		return nil
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return fmt.Errorf("can't start migration %s: %w", name, err)
+	}
+
+	if err = apply(tx); err != nil {
+		_ = tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("INSERT INTO schema_migrations(name, applied_at) VALUES(?, ?)", name, time.Now().Unix()); err != nil {
+		_ = tx.Rollback()
+		return fmt.Errorf("can't save migration mark: %w", err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("can't commit migration %s: %w", name, err)
+	}
+	return nil
+}
+
 func getUserName(from *models.User) string {
 	if from.Username != "" {
 		return from.Username
@@ -404,6 +602,16 @@ func getMessageAuthor(msg *models.Message) (int64, string, bool) {
 	return 0, "", false
 }
 
+func getMessageText(msg *models.Message) string {
+	if msg == nil {
+		return ""
+	}
+	if msg.Text != "" {
+		return msg.Text
+	}
+	return msg.Caption
+}
+
 func getForwardTarget(origin *models.MessageOrigin) (string, string, bool) {
 	if origin == nil {
 		return "", "", false
@@ -451,6 +659,17 @@ func upsertMessageAuthorState(tx *sql.Tx, chatID int64, messageID int, authorID
 	return err
 }
 
+func upsertMessageTextState(tx *sql.Tx, chatID int64, messageID int, text string, messageDate int) error {
+	_, err := tx.Exec(`
+		INSERT INTO message_text_state(chat_id, message_id, text, message_date)
+		VALUES (?, ?, ?, ?)
+		ON CONFLICT(chat_id, message_id) DO UPDATE SET
+			text = excluded.text,
+			message_date = excluded.message_date
+	`, chatID, messageID, text, messageDate)
+	return err
+}
+
 func getMessageAuthorState(tx *sql.Tx, chatID int64, messageID int) (int64, string, bool, error) {
 	var receiverID int64
 	var receiverName string
@@ -894,6 +1113,15 @@ func handleMsgToStats(ctx context.Context, b *bot.Bot, update *models.Update) {
 		return
 	}
 
+	if text := storedMessageText(chatID, getMessageText(update.Message)); text != "" {
+		if err = upsertMessageTextState(tx, chatID, update.Message.ID, text, msgDate); err != nil {
+			_ = tx.Rollback()
+			log.Println("Can't save message text state")
+			log.Println(err)
+			return
+		}
+	}
+
 	if update.Message.ForwardOrigin != nil {
 		if err = upsertForwardGiven(tx, chatID, authorID, authorName, dayDate, 1, msgDate); err != nil {
 			_ = tx.Rollback()
@@ -178,3 +178,149 @@ CREATE TABLE IF NOT EXISTS message_author_state (
 );
 
 CREATE INDEX IF NOT EXISTS idx_message_author_state_chat_msg ON message_author_state(chat_id, message_id);
+CREATE INDEX IF NOT EXISTS idx_message_author_state_author ON message_author_state(chat_id, author_user_id, updated_at);
+
+CREATE TABLE IF NOT EXISTS message_text_state (
+    chat_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    message_date INTEGER NOT NULL,
+    PRIMARY KEY(chat_id, message_id)
+);
+
+CREATE TABLE IF NOT EXISTS message_edits (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id INTEGER NOT NULL,
+    message_id INTEGER NOT NULL,
+    text TEXT NOT NULL,
+    edited_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_message_edits_message ON message_edits(chat_id, message_id);
+
+CREATE TABLE IF NOT EXISTS quote_chat_binding (
+    chat_id INTEGER PRIMARY KEY,
+    channel TEXT NOT NULL,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quotes (
+    chat TEXT NOT NULL,
+    quote_id INTEGER NOT NULL,
+    created_at INTEGER NOT NULL,
+    added_by INTEGER NOT NULL DEFAULT 0,
+    author TEXT NOT NULL,
+    text TEXT NOT NULL,
+    deleted_at INTEGER NOT NULL DEFAULT 0,
+    deleted_by INTEGER NOT NULL DEFAULT 0,
+    search_text TEXT NOT NULL DEFAULT '',
+    PRIMARY KEY(chat, quote_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_quotes_chat_author ON quotes(chat, author);
+
+CREATE TABLE IF NOT EXISTS quote_history (
+    chat TEXT NOT NULL,
+    quote_id INTEGER NOT NULL,
+    action TEXT NOT NULL,
+    author TEXT NOT NULL,
+    text TEXT NOT NULL,
+    changed_by INTEGER NOT NULL,
+    changed_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_quote_history_chat_quote ON quote_history(chat, quote_id);
+
+CREATE TABLE IF NOT EXISTS quote_votes (
+    chat TEXT NOT NULL,
+    quote_id INTEGER NOT NULL,
+    user_id INTEGER NOT NULL,
+    vote INTEGER NOT NULL,
+    updated_at INTEGER NOT NULL,
+    PRIMARY KEY(chat, quote_id, user_id)
+);
+
+CREATE TABLE IF NOT EXISTS quote_tags (
+    chat TEXT NOT NULL,
+    quote_id INTEGER NOT NULL,
+    tag TEXT NOT NULL,
+    PRIMARY KEY(chat, quote_id, tag)
+);
+
+CREATE INDEX IF NOT EXISTS idx_quote_tags_chat_tag ON quote_tags(chat, tag);
+
+CREATE TABLE IF NOT EXISTS quote_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat TEXT NOT NULL,
+    query TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quote_drafts (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat TEXT NOT NULL,
+    quote_date INTEGER NOT NULL,
+    added_by INTEGER NOT NULL,
+    author TEXT NOT NULL,
+    text TEXT NOT NULL,
+    tags TEXT NOT NULL DEFAULT '',
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quote_moderation (
+    chat_id INTEGER PRIMARY KEY,
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quote_submissions (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id INTEGER NOT NULL,
+    chat TEXT NOT NULL,
+    quote_date INTEGER NOT NULL,
+    added_by INTEGER NOT NULL,
+    author TEXT NOT NULL,
+    text TEXT NOT NULL,
+    tags TEXT NOT NULL DEFAULT '',
+    status TEXT NOT NULL DEFAULT 'pending',
+    quote_id INTEGER NOT NULL DEFAULT 0,
+    reviewed_by INTEGER NOT NULL DEFAULT 0,
+    reviewed_at INTEGER NOT NULL DEFAULT 0,
+    created_at INTEGER NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_quote_submissions_chat_status ON quote_submissions(chat_id, status);
+
+CREATE TABLE IF NOT EXISTS quote_of_day (
+    chat_id INTEGER PRIMARY KEY,
+    post_time TEXT NOT NULL,
+    last_posted_day TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS quote_of_day_posted (
+    chat_id INTEGER NOT NULL,
+    chat TEXT NOT NULL,
+    quote_id INTEGER NOT NULL,
+    posted_at INTEGER NOT NULL,
+    PRIMARY KEY(chat_id, chat, quote_id)
+);
+
+CREATE TABLE IF NOT EXISTS log_searches (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    chat_id INTEGER NOT NULL,
+    query TEXT NOT NULL,
+    created_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS chat_log_settings (
+    chat_id INTEGER PRIMARY KEY,
+    disabled INTEGER NOT NULL DEFAULT 0,
+    retention_days INTEGER NOT NULL DEFAULT 0,
+    redact TEXT NOT NULL DEFAULT '',
+    updated_at INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS seen_opt_out (
+    user_id INTEGER PRIMARY KEY,
+    updated_at INTEGER NOT NULL
+);
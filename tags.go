@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strings"
+	"unicode"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+// normalizeQuoteTag drops the leading # and lowercases the tag, tags may
+// contain only letters, digits and underscores.
+func normalizeQuoteTag(tag string) string {
+	tag = normalizeSearchText(strings.TrimPrefix(tag, "#"))
+	for _, r := range tag {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' {
+			return ""
+		}
+	}
+	return tag
+}
+
+// splitQuoteTags cuts #tags from the end of the command text, so
+// "!aq some text #work #fun" gives "!aq some text" and [work fun].
+func splitQuoteTags(text string) (string, []string) {
+	var tags []string
+	rest := strings.TrimRightFunc(text, unicode.IsSpace)
+	for {
+		start := strings.LastIndexFunc(rest, unicode.IsSpace)
+		word := rest[start+1:]
+		if start < 0 || !strings.HasPrefix(word, "#") {
+			break
+		}
+		tag := normalizeQuoteTag(word)
+		if tag == "" {
+			break
+		}
+		tags = append([]string{tag}, tags...)
+		rest = strings.TrimRightFunc(rest[:start], unicode.IsSpace)
+	}
+	return rest, tags
+}
+
+func saveQuoteTags(tx *sql.Tx, channel string, id int, tags []string) error {
+	for _, tag := range tags {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO quote_tags(chat, quote_id, tag) VALUES (?, ?, ?)", channel, id, tag); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func handleTags(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote tags")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	channel := getQuoteChannel(update.Message.Chat.ID)
+	tags, err := loadReactionStats(`
+		SELECT t.tag, COUNT(*) AS cnt
+		FROM quote_tags t
+		JOIN quotes q ON q.chat = t.chat AND q.quote_id = t.quote_id
+		WHERE t.chat = ? AND q.deleted_at = 0
+		GROUP BY t.tag
+		ORDER BY cnt DESC, t.tag
+	`, channel)
+	if err != nil {
+		log.Println("Can't get quote tags")
+		log.Println(err)
+		return
+	}
+
+	if len(tags) == 0 {
+		sendText(ctx, b, update, "Тегов пока нет, добавить: !aq текст #тег")
+		return
+	}
+
+	msg := "Теги цитат:\n"
+	for _, item := range tags {
+		msg += fmt.Sprintf("#%s: %d\n", item.name, item.count)
+	}
+	sendText(ctx, b, update, msg)
+}
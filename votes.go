@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-telegram/bot"
+	"github.com/go-telegram/bot/models"
+)
+
+const quoteVotePrefix = "qv:"
+
+func quoteVoteKeyboard(q Quote) *models.InlineKeyboardMarkup {
+	return &models.InlineKeyboardMarkup{
+		InlineKeyboard: [][]models.InlineKeyboardButton{
+			{
+				{Text: "👍", CallbackData: fmt.Sprintf("%s%d:1", quoteVotePrefix, q.id)},
+				{Text: "👎", CallbackData: fmt.Sprintf("%s%d:-1", quoteVotePrefix, q.id)},
+			},
+		},
+	}
+}
+
+// sendQuote posts a single quote with the voting buttons.
+func sendQuote(ctx context.Context, b *bot.Bot, update *models.Update, q Quote) {
+	params := &bot.SendMessageParams{
+		ChatID:      update.Message.Chat.ID,
+		Text:        formatQuotes([]Quote{q}),
+		ReplyMarkup: quoteVoteKeyboard(q),
+	}
+	if update.Message.MessageThreadID != 0 {
+		params.MessageThreadID = update.Message.MessageThreadID
+	}
+
+	if _, err := b.SendMessage(ctx, params); err != nil {
+		log.Println("Can't send quote")
+		log.Println(err)
+	}
+}
+
+func parseQuoteVote(data string) (int, int, bool) {
+	parts := strings.Split(strings.TrimPrefix(data, quoteVotePrefix), ":")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	vote, err := strconv.Atoi(parts[1])
+	if err != nil || (vote != 1 && vote != -1) {
+		return 0, 0, false
+	}
+	return id, vote, true
+}
+
+// saveQuoteVote stores the user's vote, a repeated press of the same button
+// takes the vote back. It reports whether the vote is counted now.
+func saveQuoteVote(ctx context.Context, channel string, quoteId int, userID int64, vote int) (bool, error) {
+	tx, err := statsDB.BeginTx(ctx, nil)
+	if err != nil {
+		return false, err
+	}
+
+	var prevVote int
+	err = tx.QueryRow("SELECT vote FROM quote_votes WHERE chat = ? AND quote_id = ? AND user_id = ?", channel, quoteId, userID).Scan(&prevVote)
+	if err != nil && err != sql.ErrNoRows {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	counted := true
+	if prevVote == vote {
+		_, err = tx.Exec("DELETE FROM quote_votes WHERE chat = ? AND quote_id = ? AND user_id = ?", channel, quoteId, userID)
+		counted = false
+	} else {
+		_, err = tx.Exec(`
+			INSERT INTO quote_votes(chat, quote_id, user_id, vote, updated_at)
+			VALUES (?, ?, ?, ?, ?)
+			ON CONFLICT(chat, quote_id, user_id) DO UPDATE SET
+				vote = excluded.vote,
+				updated_at = excluded.updated_at
+		`, channel, quoteId, userID, vote, time.Now().Unix())
+	}
+	if err != nil {
+		_ = tx.Rollback()
+		return false, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	return counted, nil
+}
+
+func answerCallback(ctx context.Context, b *bot.Bot, update *models.Update, text string) {
+	if _, err := b.AnswerCallbackQuery(ctx, &bot.AnswerCallbackQueryParams{
+		CallbackQueryID: update.CallbackQuery.ID,
+		Text:            text,
+	}); err != nil {
+		log.Println("Can't answer callback query")
+		log.Println(err)
+	}
+}
+
+func handleQuoteVote(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle quote vote")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	msg := update.CallbackQuery.Message.Message
+	if msg == nil {
+		answerCallback(ctx, b, update, "Сообщение с цитатой слишком старое")
+		return
+	}
+
+	id, vote, ok := parseQuoteVote(update.CallbackQuery.Data)
+	if !ok {
+		answerCallback(ctx, b, update, "Странный голос")
+		return
+	}
+
+	channel := getQuoteChannel(msg.Chat.ID)
+	_, found, err := loadQuote(channel, id)
+	if err != nil {
+		log.Println("Can't load quote for vote")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+	if !found {
+		answerCallback(ctx, b, update, "Цитата не найдена")
+		return
+	}
+
+	counted, err := saveQuoteVote(ctx, channel, id, update.CallbackQuery.From.ID, vote)
+	if err != nil {
+		log.Println("Can't save quote vote")
+		log.Println(err)
+		answerCallback(ctx, b, update, "Что-то пошло не так")
+		return
+	}
+
+	q, _, err := loadQuote(channel, id)
+	if err != nil {
+		log.Println("Can't reload quote after vote")
+		log.Println(err)
+	} else if _, err = b.EditMessageText(ctx, &bot.EditMessageTextParams{
+		ChatID:      msg.Chat.ID,
+		MessageID:   msg.ID,
+		Text:        formatQuotes([]Quote{q}),
+		ReplyMarkup: quoteVoteKeyboard(q),
+	}); err != nil {
+		log.Println("Can't update quote message after vote")
+		log.Println(err)
+	}
+
+	if counted {
+		answerCallback(ctx, b, update, "Голос учтён")
+	} else {
+		answerCallback(ctx, b, update, "Голос отменён")
+	}
+}
+
+func handleTq(ctx context.Context, b *bot.Bot, update *models.Update) {
+	log.Println("Handle top quotes")
+	if statsDB == nil {
+		log.Println("stats database is not initialized")
+		return
+	}
+
+	quotes, err := loadQuotes("chat = ? AND score > 0 ORDER BY score DESC, quote_id LIMIT 10", getQuoteChannel(update.Message.Chat.ID))
+	if err != nil {
+		log.Println("Can't load top quotes")
+		log.Println(err)
+		return
+	}
+
+	if len(quotes) == 0 {
+		sendText(ctx, b, update, "Пока никто не голосовал за цитаты")
+		return
+	}
+
+	sendText(ctx, b, update, "Лучшие цитаты:\n"+formatQuotes(quotes))
+}